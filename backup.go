@@ -11,261 +11,226 @@ package rocksdb
 */
 import "C"
 import (
+	"errors"
 	"fmt"
-	"github.com/jsuserapp/ju"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 	"unsafe"
 )
 
-const (
-	testDbPath  = "./tmp/testdb"
-	testBackup1 = "./tmp/backup1"
-	testNewDb   = "./tmp/testnewdb"
-	testResore1 = "./tmp/restore1"
-)
-
-func TestBackup() {
-	opts := GetDefaultOptions()
-	db, err := Open(testDbPath, opts)
-	if ju.CheckFailure(err) {
-		return
-	}
-	defer db.Close()
-
-	//db.AddColumnFamily([]string{"tab2"}, opts)
-
-	ju.LogBlue(db.ListColumnFamily())
-
-	//cf := db.GetColumnFamily("tab1")
-	//putData(cf)
-	//listCf(cf)
-	var be BackupEngine
-	if !be.Open(testBackup1, nil) {
-		return
-	}
-	defer be.Close()
-
-	//be.CreateBackup(rdb)
-	be.GetInfo()
-
-	//if !be.Restore(testNewDb, 1) {
-	//	return
-	//}
-
-	newDb, err := Open(testNewDb, nil)
-	if ju.CheckFailure(err) {
-		return
-	}
-	defer newDb.Close()
-
-	cf := newDb.GetColumnFamily("tab1")
-	if cf == nil {
-		return
-	}
-	listCf(cf)
-}
-func listCf(cf *ColumnFamily) {
-	cf.ListPrefix(nil, func(key, val []byte) bool {
-		ju.LogGreen(string(key), "=", string(val))
-		return true
-	})
-}
-func putData(cf *ColumnFamily) {
-	for i := 0; i < 5; i++ {
-		key := fmt.Sprintf("2%d", i)
-		val := fmt.Sprintf("v%d", i)
-		err := cf.Put([]byte(key), []byte(val))
-		if ju.CheckFailure(err) {
-			return
-		}
-	}
-}
-func Restore() {
-	//RestoreBackup(testNewDb, testBackup1, 1)
-	//checkDb(testNewDb)
-	var be BackupEngine
-	if !be.Open(testBackup1, nil) {
-		return
-	}
-	defer be.Close()
-	be.GetInfo()
-}
-func checkDb(dbPath string) {
-	db, e := Open(dbPath, nil)
-	if ju.CheckFailure(e) {
-		return
-	}
-	defer func() {
-		db.Close()
-	}()
-	cfs := db.ListColumnFamily()
-	ju.LogGreen(cfs)
-	cf := db.GetColumnFamily("tab1")
-	if cf == nil {
-		return
-	}
-	cf.ListPrefix(nil, func(key, val []byte) bool {
-		ju.LogGreen(string(key), "=", string(val))
-		return true
-	})
-}
-func backup() {
-	//rdb.CreateBackup(testBackup1, 1)
+// BackupInfo 一个备份点的信息
+type BackupInfo struct {
+	ID        uint32
+	Timestamp time.Time
+	// Size 备份点引用的全部文件的大小，和其他备份点共享的文件也计算在内
+	Size     uint64
+	NumFiles uint32
 }
 
 type BackupEngine struct {
 	engine *C.rocksdb_backup_engine_t
+	dir    string
+	//readOnly 为 true 时没有 rocksdb 的备份引擎，直接读取备份目录
+	readOnly bool
+	//live 进行中的操作，Close 等待它们结束后才关闭备份引擎
+	live liveness
+	//swap DeleteBackup 关闭并重新打开备份引擎时持有写锁，其他操作通过 use 持有读锁
+	swap sync.RWMutex
+	//opts 打开备份引擎的选项，DeleteBackup 重新打开时使用
+	opts BackupOptions
 }
 
+// Close 关闭备份引擎，会等待进行中的备份、校验和恢复结束，之后的调用返回 ErrClosed
 func (be *BackupEngine) Close() {
+	if !be.live.shutdown() {
+		return
+	}
 	if be.engine != nil {
 		C.rocksdb_backup_engine_close(be.engine)
 		be.engine = nil
 	}
 }
-func (be *BackupEngine) Open(backupPath string, opts *Options) bool {
+
+// Open 打开备份目录，目录不存在会自动创建，opts 为 nil 使用默认选项。
+// opts 只提供 rate limiter，其他备份选项使用 rocksdb 的默认值，需要设置备份选项时使用 OpenBackupEngine
+func (be *BackupEngine) Open(backupPath string, opts *Options) error {
 	if be.engine != nil {
-		return true
+		return nil
 	}
+	engine, e := openBackupEngine(backupPath, opts)
+	if e != nil {
+		return e
+	}
+	be.engine = engine
+	be.dir = backupPath
+	be.opts = *backupOptionsFrom(opts)
+	be.live.reopen()
+	return nil
+}
+
+func openBackupEngine(backupPath string, opts *Options) (*C.rocksdb_backup_engine_t, error) {
 	cBack := C.CString(backupPath)
-	var engine *C.rocksdb_backup_engine_t
+	defer C.free(unsafe.Pointer(cBack))
 	if opts == nil {
 		opts = GetDefaultOptions()
 		defer opts.Close()
 	}
+	if e := opts.Set(); e != nil {
+		return nil, e
+	}
+	if opts.RateLimiter != nil {
+		return openBackupEngineOpts(backupPath, backupOptionsFrom(opts))
+	}
 	var err *C.char
 	//rocksdb_backup_engine_t* rocksdb_backup_engine_open(const rocksdb_options_t* options, const char* path, char** errptr);
-	engine = C.rocksdb_backup_engine_open(opts.handle, cBack, &err)
-	C.free(unsafe.Pointer(cBack))
+	engine := C.rocksdb_backup_engine_open(opts.handle, cBack, &err)
 	if err != nil {
-		ju.LogRed(charErr(err).Error())
-		return false
+		return nil, charErr(err)
 	}
-	be.engine = engine
-	return true
+	return engine, nil
 }
-func (be *BackupEngine) Verify(backupId int) bool {
-	var err *C.char
-	id := C.uint32_t(backupId)
-	//void rocksdb_backup_engine_verify_backup(rocksdb_backup_engine_t* be, uint32_t backup_id, char** errptr)
-	C.rocksdb_backup_engine_verify_backup(be.engine, id, &err)
-	if err != nil {
-		ju.LogRed(charErr(err).Error())
-		return false
+
+// backupOptionsFrom 返回和 openBackupEngine 等价的备份选项，备份和恢复使用和数据库相同的限速
+func backupOptionsFrom(opts *Options) *BackupOptions {
+	beOpts := GetDefaultBackupOptions()
+	if opts != nil && opts.RateLimiter != nil {
+		beOpts.BackupRateLimit = uint64(opts.RateLimiter.BytesPerSec)
+		beOpts.RestoreRateLimit = uint64(opts.RateLimiter.BytesPerSec)
 	}
-	return true
+	return beOpts
 }
 
 // CreateBackup 即使没有新的数据，这个函数也会创建一个新的备份点，但是它的内容和上一次的备份点是相同的
-func (be *BackupEngine) CreateBackup(db *Db) bool {
+func (be *BackupEngine) CreateBackup(db *Db) error {
+	if be.readOnly {
+		return errBackupReadOnly
+	}
+	if e := be.use(); e != nil {
+		return e
+	}
+	defer be.done()
+	def, e := db.useDefault()
+	if e != nil {
+		return e
+	}
+	defer def.done()
 	var err *C.char
-	//void rocksdb_backup_engine_create_new_backup(rocksdb_backup_engine_t* be, rocksdb_t* rdb, char** errptr);
-	//C.rocksdb_backup_engine_create_new_backup(be.engine, rdb.rdb.rdb, &err)
 	//void rocksdb_backup_engine_create_new_backup_flush(rocksdb_backup_engine_t* be, rocksdb_t* rdb,unsigned char flush_before_backup, char** errptr);
-	C.rocksdb_backup_engine_create_new_backup_flush(be.engine, db.GetDefault().rocks.db, 1, &err)
+	C.rocksdb_backup_engine_create_new_backup_flush(be.engine, def.rocks.db, 1, &err)
 	if err != nil {
-		ju.LogRed(charErr(err).Error())
-		return false
+		return charErr(err)
 	}
-	return true
+	return nil
 }
-func (be *BackupEngine) GetInfo() {
+
+// GetInfo 返回全部备份点的信息，按备份 ID 从小到大排列
+func (be *BackupEngine) GetInfo() ([]BackupInfo, error) {
+	if e := be.use(); e != nil {
+		return nil, e
+	}
+	defer be.done()
+	if be.readOnly {
+		return readBackupInfos(be.dir)
+	}
+	return be.backupInfos()
+}
+
+// backupInfos 读取 rocksdb 备份引擎中的备份点，调用者必须持有 use 或者 swap 的写锁
+func (be *BackupEngine) backupInfos() ([]BackupInfo, error) {
 	//const rocksdb_backup_engine_info_t* rocksdb_backup_engine_get_backup_info(rocksdb_backup_engine_t* be);
-	var backupInfo *C.rocksdb_backup_engine_info_t
-	backupInfo = C.rocksdb_backup_engine_get_backup_info(be.engine)
+	backupInfo := C.rocksdb_backup_engine_get_backup_info(be.engine)
 	if backupInfo == nil {
-		ju.LogRed("get backup into return nil")
-		return
+		return nil, ErrClosed
 	}
+	//void rocksdb_backup_engine_info_destroy(const rocksdb_backup_engine_info_t* info);
+	defer C.rocksdb_backup_engine_info_destroy(backupInfo)
 
-	//int rocksdb_backup_engine_info_count(const rocksdb_backup_engine_info_t* info);
 	count := C.rocksdb_backup_engine_info_count(backupInfo)
+	infos := make([]BackupInfo, 0, int(count))
 	var i C.int
 	for i = 0; i < count; i++ {
-		//int64_t rocksdb_backup_engine_info_timestamp(const rocksdb_backup_engine_info_t* info, int index);
-		ts := C.rocksdb_backup_engine_info_timestamp(backupInfo, i)
-		timeStamp := int64(ts)
-		//uint32_t rocksdb_backup_engine_info_backup_id(const rocksdb_backup_engine_info_t* info, int index);
-		id := uint32(C.rocksdb_backup_engine_info_backup_id(backupInfo, i))
-		//uint64_t rocksdb_backup_engine_info_size(const rocksdb_backup_engine_info_t* info, int index);
-		size := uint64(C.rocksdb_backup_engine_info_size(backupInfo, i))
-
-		tm := time.Unix(timeStamp, 0).Format("2006-01-02 15:04:05.000")
-		ju.LogMagentaF("backup info: index=%d,id=%d,time=%s,size:%d\n", i, id, tm, size)
+		infos = append(infos, BackupInfo{
+			ID:        uint32(C.rocksdb_backup_engine_info_backup_id(backupInfo, i)),
+			Timestamp: time.Unix(int64(C.rocksdb_backup_engine_info_timestamp(backupInfo, i)), 0),
+			Size:      uint64(C.rocksdb_backup_engine_info_size(backupInfo, i)),
+			NumFiles:  uint32(C.rocksdb_backup_engine_info_number_files(backupInfo, i)),
+		})
 	}
-	//void rocksdb_backup_engine_info_destroy(const rocksdb_backup_engine_info_t* info);
-	C.rocksdb_backup_engine_info_destroy(backupInfo)
+	return infos, nil
 }
-func (be *BackupEngine) Restore(restorePath string, backupId int) bool {
-	restoreOpts := C.rocksdb_restore_options_create()
-	cDb := C.CString(restorePath)
-	var err *C.char
-	if backupId < 0 {
-		//void rocksdb_backup_engine_restore_db_from_latest_backup(rocksdb_backup_engine_t* be, const char* db_dir, const char* wal_dir,
-		//    const rocksdb_restore_options_t* restore_options, char** errptr);
-		C.rocksdb_backup_engine_restore_db_from_latest_backup(be.engine, cDb, cDb, restoreOpts, &err)
-	} else {
-		id := C.uint32_t(backupId)
-		//void rocksdb_backup_engine_restore_db_from_backup(
-		//    rocksdb_backup_engine_t* be, const char* db_dir, const char* wal_dir,
-		//    const rocksdb_restore_options_t* restore_options, const uint32_t backup_id,
-		//    char** errptr);
-		C.rocksdb_backup_engine_restore_db_from_backup(be.engine, cDb, cDb, restoreOpts, id, &err)
+
+// PurgeOldBackups 删除旧的备份点，只保留最新的 keep 个，只被删除的备份点引用的共享文件也会删除
+func (be *BackupEngine) PurgeOldBackups(keep uint32) error {
+	if be.readOnly {
+		return errBackupReadOnly
 	}
-	C.free(unsafe.Pointer(cDb))
-	C.rocksdb_restore_options_destroy(restoreOpts)
-	if err != nil {
-		ju.LogRed(charErr(err).Error())
-		return false
+	if e := be.use(); e != nil {
+		return e
 	}
-	return true
-}
-func RestoreBackup(dbPath, backupPath string, backupId int) bool {
-	cBack := C.CString(backupPath)
-	var engine *C.rocksdb_backup_engine_t
-	opts := GetDefaultOptions()
-	defer opts.Close()
+	defer be.done()
 	var err *C.char
-	//rocksdb_backup_engine_t* rocksdb_backup_engine_open(const rocksdb_options_t* options, const char* path, char** errptr);
-	engine = C.rocksdb_backup_engine_open(opts.handle, cBack, &err)
-	C.free(unsafe.Pointer(cBack))
+	//void rocksdb_backup_engine_purge_old_backups(rocksdb_backup_engine_t* be, uint32_t num_backups_to_keep, char** errptr);
+	C.rocksdb_backup_engine_purge_old_backups(be.engine, C.uint32_t(keep), &err)
 	if err != nil {
-		ju.LogRed(charErr(err).Error())
-		return false
+		return charErr(err)
 	}
-	defer func() {
-		if engine != nil {
-			C.rocksdb_backup_engine_close(engine)
-			engine = nil
-		}
-	}()
+	return nil
+}
 
-	//rocksdb_restore_options_t* rocksdb_restore_options_create(void);
-	//void rocksdb_restore_options_destroy(rocksdb_restore_options_t* opt);
-	//void rocksdb_restore_options_set_keep_log_files(rocksdb_restore_options_t* opt, int v);
+// DeleteBackup 删除一个备份点。rocksdb 的 C 接口没有提供 BackupEngine::DeleteBackup，
+// 删除最旧的备份点时使用 PurgeOldBackups，删除其他备份点时关闭备份引擎，只删除备份点的元数据 meta/<id>
+// 后重新打开，rocksdb 不会再加载这个备份点。备份点的私有目录和不再被引用的共享文件由 rocksdb 的垃圾回收删除，
+// 重新打开后的第一次 CreateBackup 会先执行垃圾回收，这之前它们仍然占用空间。
+// 删除期间其他操作会等待，所以不会删除同时创建的备份点。重新打开失败时备份引擎不能再使用，之后的操作返回 ErrClosed
+func (be *BackupEngine) DeleteBackup(backupId uint32) error {
+	if be.readOnly {
+		return errBackupReadOnly
+	}
+	be.swap.Lock()
+	defer be.swap.Unlock()
+	if !be.live.acquire() {
+		return ErrClosed
+	}
+	defer be.live.release()
+	if be.engine == nil {
+		return ErrClosed
+	}
+	if be.opts.MaxValidBackupsToOpen > 0 {
+		return errors.New("DeleteBackup can not be used with MaxValidBackupsToOpen")
+	}
+	infos, e := be.backupInfos()
+	if e != nil {
+		return e
+	}
+	index := slices.IndexFunc(infos, func(info BackupInfo) bool { return info.ID == backupId })
+	if index < 0 {
+		return fmt.Errorf("backup %d does not exist", backupId)
+	}
+	var err *C.char
+	if index == 0 {
+		C.rocksdb_backup_engine_purge_old_backups(be.engine, C.uint32_t(len(infos)-1), &err)
+		if err != nil {
+			return charErr(err)
+		}
+		return nil
+	}
 
-	//s = backup_engine_ro->RestoreDBFromBackup(1, "/tmp/rocksdb_example",
-	//"/tmp/rocksdb_example");
-	//assert(s.ok());
-	//void rocksdb_backup_engine_restore_db_from_latest_backup(
-	//    rocksdb_backup_engine_t* be, const char* db_dir, const char* wal_dir,
-	//    const rocksdb_restore_options_t* restore_options, char** errptr);
-	//
-	//void rocksdb_backup_engine_restore_db_from_backup(
-	//    rocksdb_backup_engine_t* be, const char* db_dir, const char* wal_dir,
-	//    const rocksdb_restore_options_t* restore_options, const uint32_t backup_id,
-	//    char** errptr);
-	restoreOpts := C.rocksdb_restore_options_create()
-	cDb := C.CString(dbPath)
-	id := C.uint32_t(backupId)
-	C.rocksdb_backup_engine_restore_db_from_backup(engine, cDb, cDb, restoreOpts, id, &err)
-	C.free(unsafe.Pointer(cDb))
-	C.rocksdb_restore_options_destroy(restoreOpts)
-	if err != nil {
-		ju.LogRed(charErr(err).Error())
-		return false
+	C.rocksdb_backup_engine_close(be.engine)
+	be.engine = nil
+	e = os.Remove(filepath.Join(be.dir, "meta", strconv.FormatUint(uint64(backupId), 10)))
+	//重新打开时不能删除已有的备份
+	opts := be.opts
+	opts.DestroyOldData = false
+	engine, oe := openBackupEngineOpts(be.dir, &opts)
+	if oe != nil {
+		return errors.Join(e, oe)
 	}
-	return true
+	be.engine = engine
+	return e
 }
 
 //func main() {
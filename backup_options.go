@@ -0,0 +1,135 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// ShareFilesNaming 共享文件的命名方式，只在 ShareTableFiles 为 true 时起作用，参考 rocksdb/utilities/backup_engine.h
+type ShareFilesNaming uint32
+
+const (
+	// ShareFilesLegacyCrc32cAndFileSize 文件名包含 crc32c 校验和以及文件大小，需要读取整个文件计算校验和
+	ShareFilesLegacyCrc32cAndFileSize ShareFilesNaming = 1
+	// ShareFilesUseDbSessionId 文件名包含生成 SST 的数据库会话 ID，不需要读取文件，这是 rocksdb 的默认方式
+	ShareFilesUseDbSessionId ShareFilesNaming = 2
+	// ShareFilesFlagIncludeFileSize 和上面的方式组合使用，文件名中附加文件大小
+	ShareFilesFlagIncludeFileSize ShareFilesNaming = 1 << 31
+)
+
+// BackupOptions 备份引擎的选项，使用 GetDefaultBackupOptions 获取 rocksdb 的默认值后再修改。
+// 布尔字段总是会设置，数值字段为 0 时使用 rocksdb 的默认值。
+type BackupOptions struct {
+	// ShareTableFiles 多个备份点共享相同的 SST 文件，只复制新增的文件
+	ShareTableFiles bool `json:"share_table_files"`
+	// Sync 每个文件写完后调用 fsync，临时备份可以关闭以提高速度，但是断电时备份可能损坏
+	Sync bool `json:"sync"`
+	// DestroyOldData 打开时删除备份目录中已有的全部备份
+	DestroyOldData bool `json:"destroy_old_data"`
+	// BackupLogFiles 是否备份 WAL 文件，关闭时需要在备份前刷写 memtable，否则未刷写的数据不在备份中
+	BackupLogFiles bool `json:"backup_log_files"`
+	// BackupRateLimit 备份时每秒最多写入的字节数，0 表示不限速
+	BackupRateLimit uint64 `json:"backup_rate_limit"`
+	// RestoreRateLimit 恢复时每秒最多写入的字节数，0 表示不限速
+	RestoreRateLimit uint64 `json:"restore_rate_limit"`
+	// MaxBackgroundOperations 备份和恢复时并行复制文件的线程数，rocksdb 默认值是 1
+	MaxBackgroundOperations int `json:"max_background_operations"`
+	// CallbackTriggerIntervalSize 复制多少字节后触发一次进度回调，rocksdb 默认值是 4MB
+	CallbackTriggerIntervalSize uint64 `json:"callback_trigger_interval_size"`
+	// MaxValidBackupsToOpen 打开时只加载最新的 n 个备份点，用于只读或恢复的场景可以加快打开速度，
+	// 设置后不能再创建备份或者清理旧备份
+	MaxValidBackupsToOpen int `json:"max_valid_backups_to_open"`
+	// ShareFilesWithChecksumNaming 共享文件的命名方式
+	ShareFilesWithChecksumNaming ShareFilesNaming `json:"share_files_with_checksum_naming"`
+}
+
+// GetDefaultBackupOptions 返回 rocksdb 默认的备份选项
+func GetDefaultBackupOptions() *BackupOptions {
+	return &BackupOptions{
+		ShareTableFiles: true,
+		Sync:            true,
+		BackupLogFiles:  true,
+	}
+}
+
+// Validate 检查选项的值是否有效，返回的错误都可以用 errors.Is(e, ErrInvalidOptions) 判断
+func (bo *BackupOptions) Validate() error {
+	if bo.MaxBackgroundOperations < 0 {
+		return &OptionError{Field: "BackupOptions.MaxBackgroundOperations", Value: fmt.Sprint(bo.MaxBackgroundOperations), Reason: "must not be negative"}
+	}
+	if bo.MaxValidBackupsToOpen < 0 {
+		return &OptionError{Field: "BackupOptions.MaxValidBackupsToOpen", Value: fmt.Sprint(bo.MaxValidBackupsToOpen), Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// create 生成 rocksdb 的备份选项，用完需要调用 rocksdb_backup_engine_options_destroy
+func (bo *BackupOptions) create(backupPath string) *C.rocksdb_backup_engine_options_t {
+	cBack := C.CString(backupPath)
+	defer C.free(unsafe.Pointer(cBack))
+	beOpts := C.rocksdb_backup_engine_options_create(cBack)
+	C.rocksdb_backup_engine_options_set_share_table_files(beOpts, boolToUChar(bo.ShareTableFiles))
+	C.rocksdb_backup_engine_options_set_sync(beOpts, boolToUChar(bo.Sync))
+	C.rocksdb_backup_engine_options_set_destroy_old_data(beOpts, boolToUChar(bo.DestroyOldData))
+	C.rocksdb_backup_engine_options_set_backup_log_files(beOpts, boolToUChar(bo.BackupLogFiles))
+	if bo.BackupRateLimit > 0 {
+		C.rocksdb_backup_engine_options_set_backup_rate_limit(beOpts, C.uint64_t(bo.BackupRateLimit))
+	}
+	if bo.RestoreRateLimit > 0 {
+		C.rocksdb_backup_engine_options_set_restore_rate_limit(beOpts, C.uint64_t(bo.RestoreRateLimit))
+	}
+	if bo.MaxBackgroundOperations > 0 {
+		C.rocksdb_backup_engine_options_set_max_background_operations(beOpts, C.int(bo.MaxBackgroundOperations))
+	}
+	if bo.CallbackTriggerIntervalSize > 0 {
+		C.rocksdb_backup_engine_options_set_callback_trigger_interval_size(beOpts, C.uint64_t(bo.CallbackTriggerIntervalSize))
+	}
+	if bo.MaxValidBackupsToOpen > 0 {
+		C.rocksdb_backup_engine_options_set_max_valid_backups_to_open(beOpts, C.int(bo.MaxValidBackupsToOpen))
+	}
+	if bo.ShareFilesWithChecksumNaming != 0 {
+		//C 接口的参数是 int，包含 kFlagIncludeFileSize 时按位转换为负数
+		C.rocksdb_backup_engine_options_set_share_files_with_checksum_naming(beOpts, C.int(int32(bo.ShareFilesWithChecksumNaming)))
+	}
+	return beOpts
+}
+
+// OpenBackupEngine 使用备份选项打开备份目录，opts 为 nil 使用 GetDefaultBackupOptions
+func OpenBackupEngine(backupPath string, opts *BackupOptions) (*BackupEngine, error) {
+	if opts == nil {
+		opts = GetDefaultBackupOptions()
+	}
+	engine, e := openBackupEngineOpts(backupPath, opts)
+	if e != nil {
+		return nil, e
+	}
+	return &BackupEngine{engine: engine, dir: backupPath, opts: *opts}, nil
+}
+
+func openBackupEngineOpts(backupPath string, opts *BackupOptions) (*C.rocksdb_backup_engine_t, error) {
+	if e := opts.Validate(); e != nil {
+		return nil, e
+	}
+	beOpts := opts.create(backupPath)
+	defer C.rocksdb_backup_engine_options_destroy(beOpts)
+	env := C.rocksdb_create_default_env()
+	defer C.rocksdb_env_destroy(env)
+
+	var err *C.char
+	//rocksdb_backup_engine_t* rocksdb_backup_engine_open_opts(const rocksdb_backup_engine_options_t* options, rocksdb_env_t* env, char** errptr);
+	engine := C.rocksdb_backup_engine_open_opts(beOpts, env, &err)
+	if err != nil {
+		return nil, charErr(err)
+	}
+	return engine, nil
+}
@@ -0,0 +1,170 @@
+package rocksdb
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// BackupResult 一次定时备份的结果
+type BackupResult struct {
+	// Backup 新生成的备份点，Err 不为 nil 时可能是零值
+	Backup BackupInfo
+	// Start 开始备份的时间
+	Start time.Time
+	// Duration 备份、校验和清理一共花费的时间
+	Duration time.Duration
+	// Verified 新的备份点是否通过了校验
+	Verified bool
+	// Err 备份、校验或者清理失败的原因
+	Err error
+}
+
+// BackupScheduler 按固定的周期备份数据库，每次备份后校验新的备份点，然后按保留规则清理旧的备份点。
+// KeepLast、KeepHourly 和 KeepDaily 保留的备份点取并集，例如每小时备份一次，KeepHourly 为 24、KeepDaily 为 7
+// 时保留最近 24 个小时每小时的备份和最近 7 天每天的备份。时间按 BackupInfo.Timestamp 的本地时间划分。
+// 运行期间 Engine 由 BackupScheduler 使用，不要同时在其他 goroutine 中调用它的方法。
+type BackupScheduler struct {
+	Engine *BackupEngine
+	Db     *Db
+	// Interval 两次备份的间隔
+	Interval time.Duration
+	// KeepLast 保留最新的备份点数量
+	KeepLast uint32
+	// KeepHourly 保留最近的多少个小时，每个小时保留这个小时中最新的备份点
+	KeepHourly uint32
+	// KeepDaily 保留最近的多少天，每天保留这一天中最新的备份点
+	KeepDaily uint32
+	// SkipVerify 为 true 时不校验新的备份点
+	SkipVerify bool
+	// VerifyChecksum 为 true 时校验新备份点全部文件的 crc32c，否则只检查文件是否存在和大小
+	VerifyChecksum bool
+	// Immediate 为 true 时启动后立即备份一次，否则等待一个 Interval
+	Immediate bool
+	// OnResult 每次备份后调用，可以为 nil
+	OnResult func(BackupResult)
+}
+
+var errSchedulerConfig = errors.New("backup scheduler needs Engine, Db and a positive Interval")
+
+// Run 开始定时备份，阻塞直到 ctx 被取消，取消时正在进行的备份会先完成，然后返回 nil。
+// rocksdb 的 C 接口不能中断正在进行的备份，所以返回的时间取决于当前备份的剩余工作量。
+func (s *BackupScheduler) Run(ctx context.Context) error {
+	if s.Engine == nil || s.Db == nil || s.Interval <= 0 {
+		return errSchedulerConfig
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if s.Immediate {
+		s.report(s.RunOnce())
+	}
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			//ticker 和 ctx 同时就绪时，select 是随机选择的
+			if ctx.Err() != nil {
+				return nil
+			}
+			s.report(s.RunOnce())
+		}
+	}
+}
+
+// RunOnce 立即执行一次备份、校验和清理。校验失败时不清理旧的备份点，避免只剩下损坏的备份
+func (s *BackupScheduler) RunOnce() (result BackupResult) {
+	result.Start = time.Now()
+	defer func() {
+		result.Duration = time.Since(result.Start)
+	}()
+	before, e := s.Engine.GetInfo()
+	if e != nil {
+		result.Err = e
+		return result
+	}
+	if result.Err = s.Engine.CreateBackup(s.Db); result.Err != nil {
+		return result
+	}
+	infos, e := s.Engine.GetInfo()
+	if e != nil {
+		result.Err = e
+		return result
+	}
+	//新备份点的 ID 比备份前的全部 ID 都大，不能假设它是 infos 的最后一个
+	var maxBefore uint32
+	for _, info := range before {
+		maxBefore = max(maxBefore, info.ID)
+	}
+	found := false
+	for _, info := range infos {
+		if info.ID > maxBefore && (!found || info.ID > result.Backup.ID) {
+			result.Backup, found = info, true
+		}
+	}
+	if !found {
+		result.Err = errors.New("backup scheduler: the new backup is not found after CreateBackup")
+		return result
+	}
+	if !s.SkipVerify {
+		if result.Err = s.Engine.Verify(result.Backup.ID, s.VerifyChecksum); result.Err != nil {
+			return result
+		}
+		result.Verified = true
+	}
+	result.Err = s.purge(infos)
+	return result
+}
+
+// purge 删除保留规则之外的备份点，全部规则为 0 时不清理
+func (s *BackupScheduler) purge(infos []BackupInfo) error {
+	if s.KeepLast == 0 && s.KeepHourly == 0 && s.KeepDaily == 0 {
+		return nil
+	}
+	var errs []error
+	//infos 从旧到新排列，先删除最旧的，DeleteBackup 删除最旧的备份点不需要重新打开备份引擎
+	for _, id := range s.expired(infos) {
+		if e := s.Engine.DeleteBackup(id); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// expired 返回保留规则之外的备份点 ID，infos 必须按 ID 从小到大排列
+func (s *BackupScheduler) expired(infos []BackupInfo) []uint32 {
+	keep := map[uint32]bool{}
+	//从新到旧，每个时间段保留第一个遇到的备份点，直到保留了足够的时间段
+	keepPeriods := func(count uint32, period func(time.Time) string) {
+		seen := map[string]bool{}
+		for i := len(infos) - 1; i >= 0 && uint32(len(seen)) < count; i-- {
+			key := period(infos[i].Timestamp.Local())
+			if !seen[key] {
+				seen[key] = true
+				keep[infos[i].ID] = true
+			}
+		}
+	}
+	for i := len(infos) - 1; i >= 0 && len(infos)-i <= int(s.KeepLast); i-- {
+		keep[infos[i].ID] = true
+	}
+	keepPeriods(s.KeepHourly, func(t time.Time) string { return t.Format("2006-01-02 15") })
+	keepPeriods(s.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") })
+
+	var ids []uint32
+	for _, info := range infos {
+		if !keep[info.ID] {
+			ids = append(ids, info.ID)
+		}
+	}
+	return ids
+}
+
+func (s *BackupScheduler) report(result BackupResult) {
+	if s.OnResult != nil {
+		s.OnResult(result)
+	}
+}
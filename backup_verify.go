@@ -0,0 +1,282 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errBackupReadOnly = errors.New("backup engine is opened read only")
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// VerifyResult 一个备份点的校验结果，Err 为 nil 表示校验通过
+type VerifyResult struct {
+	ID  uint32
+	Err error
+}
+
+// backupFile 备份点元数据中记录的一个文件，路径相对于备份目录
+type backupFile struct {
+	name   string
+	crc32c uint32
+	//size 小于 0 表示元数据没有记录大小
+	size   int64
+	hasCrc bool
+}
+
+type backupMeta struct {
+	id        uint32
+	timestamp int64
+	files     []backupFile
+	//nonIgnorable 元数据中不认识的 ni:: 字段，rocksdb 规定不认识这些字段时不能使用这个备份点
+	nonIgnorable []string
+}
+
+// OpenBackupEngineReadOnly 以只读方式打开备份目录，可以在其他进程正在备份时安全地查看和校验。
+// rocksdb 的 C 接口没有提供 BackupEngineReadOnly，所以这里不创建 rocksdb 的备份引擎，而是直接读取备份目录中的
+// 元数据，只支持 GetInfo、Verify 和 VerifyAll。正在写入的备份点还没有元数据文件，不会被读取。
+// 元数据是 rocksdb 的内部格式，不能解析的备份点在 GetInfo 中只有 ID，Verify 返回原因，需要换用 rocksdb 的备份引擎校验。
+func OpenBackupEngineReadOnly(backupPath string) (*BackupEngine, error) {
+	stat, e := os.Stat(filepath.Join(backupPath, "meta"))
+	if e != nil {
+		return nil, e
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("%s is not a backup directory", backupPath)
+	}
+	return &BackupEngine{dir: backupPath, readOnly: true}, nil
+}
+
+// Verify 校验备份点，检查文件是否存在以及大小是否正确，withChecksum 为 true 时还会读取全部文件重新计算 crc32c，
+// 备份点较大时需要较长的时间
+func (be *BackupEngine) Verify(backupId uint32, withChecksum bool) error {
+	if e := be.use(); e != nil {
+		return e
+	}
+	defer be.done()
+	if !be.readOnly {
+		var err *C.char
+		//void rocksdb_backup_engine_verify_backup(rocksdb_backup_engine_t* be, uint32_t backup_id, char** errptr)
+		C.rocksdb_backup_engine_verify_backup(be.engine, C.uint32_t(backupId), &err)
+		if err != nil {
+			return charErr(err)
+		}
+		if !withChecksum {
+			return nil
+		}
+	}
+	//C 接口的 verify_backup 不能校验 checksum，按元数据中的 crc32c 自行校验
+	meta, e := readBackupMeta(be.dir, backupId)
+	if e != nil {
+		return e
+	}
+	return meta.verify(be.dir, withChecksum)
+}
+
+// VerifyAll 按 Verify 校验全部备份点，返回每个备份点的结果，按备份 ID 从小到大排列。
+// 只有读取备份列表失败时才返回错误，单个备份点的错误保存在 VerifyResult.Err
+func (be *BackupEngine) VerifyAll(withChecksum bool) ([]VerifyResult, error) {
+	infos, e := be.GetInfo()
+	if e != nil {
+		return nil, e
+	}
+	results := make([]VerifyResult, len(infos))
+	for i, info := range infos {
+		results[i] = VerifyResult{ID: info.ID, Err: be.Verify(info.ID, withChecksum)}
+	}
+	return results, nil
+}
+
+func (meta *backupMeta) verify(dir string, withChecksum bool) error {
+	if len(meta.nonIgnorable) > 0 {
+		return fmt.Errorf("backup %d: unsupported meta fields %v", meta.id, meta.nonIgnorable)
+	}
+	for _, file := range meta.files {
+		path := filepath.Join(dir, filepath.FromSlash(file.name))
+		stat, e := os.Stat(path)
+		if e != nil {
+			return fmt.Errorf("backup %d: %w", meta.id, e)
+		}
+		if file.size >= 0 && stat.Size() != file.size {
+			return fmt.Errorf("backup %d: %s size is %d, expected %d", meta.id, file.name, stat.Size(), file.size)
+		}
+		if !withChecksum {
+			continue
+		}
+		if !file.hasCrc {
+			return fmt.Errorf("backup %d: %s has no crc32c", meta.id, file.name)
+		}
+		sum, e := fileCrc32c(path)
+		if e != nil {
+			return fmt.Errorf("backup %d: %w", meta.id, e)
+		}
+		if sum != file.crc32c {
+			return fmt.Errorf("backup %d: %s crc32c is %d, expected %d", meta.id, file.name, sum, file.crc32c)
+		}
+	}
+	return nil
+}
+
+func fileCrc32c(path string) (uint32, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return 0, e
+	}
+	defer f.Close()
+	h := crc32.New(castagnoliTable)
+	if _, e = io.Copy(h, f); e != nil {
+		return 0, e
+	}
+	return h.Sum32(), nil
+}
+
+// readBackupMeta 解析备份目录中 meta/<id> 文件。这是 rocksdb 内部的格式，不是公开的接口：
+//
+//	[schema_version 1 或者 2.x]
+//	时间戳
+//	sequence number
+//	[metadata <十六进制>]
+//	[其他字段 <值>]
+//	文件数量
+//	shared_checksum/000007_2894567812_590.sst crc32 2894567812 [size 590] [temp 0] [其他字段 <值>]
+//
+// 和 rocksdb 一样，schema_version 的主版本不是 1 或者 2 时返回错误，不认识的字段跳过。
+// 名称以 ni:: 开头的字段 rocksdb 规定不能忽略，这里也跳过，但是记录在 nonIgnorable 中，校验时返回错误
+func readBackupMeta(dir string, id uint32) (*backupMeta, error) {
+	data, e := os.ReadFile(filepath.Join(dir, "meta", strconv.FormatUint(uint64(id), 10)))
+	if e != nil {
+		return nil, e
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	bad := func(reason string) error {
+		return fmt.Errorf("backup %d: unsupported meta file, %s", id, reason)
+	}
+	i := 0
+	if version, ok := strings.CutPrefix(lines[0], "schema_version "); ok {
+		if major, _, _ := strings.Cut(version, "."); major != "1" && major != "2" {
+			return nil, bad("schema version " + version)
+		}
+		i++
+	}
+	//时间戳和 sequence number
+	if i+2 > len(lines) {
+		return nil, bad("missing timestamp")
+	}
+	meta := &backupMeta{id: id}
+	if meta.timestamp, e = strconv.ParseInt(lines[i], 10, 64); e != nil {
+		return nil, bad("invalid timestamp")
+	}
+	if _, e = strconv.ParseUint(lines[i+1], 10, 64); e != nil {
+		return nil, bad("invalid sequence number")
+	}
+	i += 2
+	//文件数量之前是 metadata 和其他字段
+	count := -1
+	for ; i < len(lines) && count < 0; i++ {
+		if n, e := strconv.Atoi(lines[i]); e == nil && n >= 0 {
+			count = n
+		} else if name, _, _ := strings.Cut(lines[i], " "); strings.HasPrefix(name, "ni::") {
+			meta.nonIgnorable = append(meta.nonIgnorable, name)
+		}
+	}
+	if count < 0 || i+count != len(lines) {
+		return nil, bad("file count does not match")
+	}
+	for _, line := range lines[i:] {
+		fields := strings.Fields(line)
+		if len(fields)%2 != 1 {
+			return nil, bad(fmt.Sprintf("invalid file line %q", line))
+		}
+		file := backupFile{name: fields[0], size: -1}
+		for k := 1; k < len(fields); k += 2 {
+			switch fields[k] {
+			case "crc32":
+				v, e := strconv.ParseUint(fields[k+1], 10, 32)
+				if e != nil {
+					return nil, bad("invalid crc32 of " + file.name)
+				}
+				file.crc32c, file.hasCrc = uint32(v), true
+			case "size":
+				if file.size, e = strconv.ParseInt(fields[k+1], 10, 64); e != nil {
+					return nil, bad("invalid size of " + file.name)
+				}
+			default:
+				if strings.HasPrefix(fields[k], "ni::") {
+					meta.nonIgnorable = append(meta.nonIgnorable, fields[k])
+				}
+			}
+		}
+		meta.files = append(meta.files, file)
+	}
+	return meta, nil
+}
+
+// listBackupIds 返回备份目录中全部备份点的 ID，从小到大排列，临时的元数据文件 .<id>.tmp 会被忽略
+func listBackupIds(dir string) ([]uint32, error) {
+	entries, e := os.ReadDir(filepath.Join(dir, "meta"))
+	if e != nil {
+		return nil, e
+	}
+	var ids []uint32
+	for _, entry := range entries {
+		id, e := strconv.ParseUint(entry.Name(), 10, 32)
+		if e == nil && !entry.IsDir() {
+			ids = append(ids, uint32(id))
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// readBackupInfos 读取备份目录中全部备份点的信息。元数据不能解析的备份点只返回 ID，
+// 不影响其他备份点，原因由 Verify 返回。读取期间被删除的备份点会被跳过
+func readBackupInfos(dir string) ([]BackupInfo, error) {
+	ids, e := listBackupIds(dir)
+	if e != nil {
+		return nil, e
+	}
+
+	infos := make([]BackupInfo, 0, len(ids))
+	for _, id := range ids {
+		meta, e := readBackupMeta(dir, id)
+		if os.IsNotExist(e) {
+			continue
+		}
+		if e != nil {
+			infos = append(infos, BackupInfo{ID: id})
+			continue
+		}
+		info := BackupInfo{ID: id, Timestamp: time.Unix(meta.timestamp, 0), NumFiles: uint32(len(meta.files))}
+		for _, file := range meta.files {
+			size := file.size
+			if size < 0 {
+				if stat, e := os.Stat(filepath.Join(dir, filepath.FromSlash(file.name))); e == nil {
+					size = stat.Size()
+				}
+			}
+			if size > 0 {
+				info.Size += uint64(size)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
@@ -13,21 +13,53 @@ import "C"
 import (
 	"bytes"
 	"errors"
+	"sync"
 	"unsafe"
 )
 
 type ColumnFamily struct {
 	rocks  *dbType
 	handle *C.rocksdb_column_family_handle_t
+	//opts 创建或者打开 column family 时使用的选项副本，SetOptions 修改后同步更新
+	opts    *C.rocksdb_options_t
+	optsMut sync.Mutex
+	//live 这个 column family 上进行中的操作，Close 等待它们结束后才释放句柄
+	live liveness
 }
 
+// Close 释放 column family 的句柄，会等待进行中的操作结束，由 Db.Close 和 DeleteColumnFamily 调用
 func (cf *ColumnFamily) Close() {
+	if !cf.live.shutdown() {
+		return
+	}
 	if cf.handle != nil {
 		C.rocksdb_column_family_handle_destroy(cf.handle)
 		cf.handle = nil
 	}
+	cf.optsMut.Lock()
+	if cf.opts != nil {
+		C.rocksdb_options_destroy(cf.opts)
+		cf.opts = nil
+	}
+	cf.optsMut.Unlock()
+}
+
+// Options 返回打开或者创建 column family 时使用的选项，返回的 Options 持有独立的 C 资源，用完需要 Close
+func (cf *ColumnFamily) Options() *Options {
+	cf.optsMut.Lock()
+	defer cf.optsMut.Unlock()
+	if cf.opts == nil {
+		return nil
+	}
+	opt := &Options{handle: C.rocksdb_options_create_copy(cf.opts)}
+	opt.Get()
+	return opt
 }
 func (cf *ColumnFamily) Put(key, value []byte) error {
+	if e := cf.use(); e != nil {
+		return e
+	}
+	defer cf.done()
 	var err *C.char
 	cKey, keyLen := toCBytes(key)
 	cValue, valLen := toCBytes(value)
@@ -35,6 +67,10 @@ func (cf *ColumnFamily) Put(key, value []byte) error {
 	return charErr(err)
 }
 func (cf *ColumnFamily) Get(key []byte) ([]byte, error) {
+	if e := cf.use(); e != nil {
+		return nil, e
+	}
+	defer cf.done()
 	cKey, keyLen := toCBytes(key)
 	var err *C.char
 	var valLen C.size_t
@@ -53,6 +89,10 @@ func (cf *ColumnFamily) Get(key []byte) ([]byte, error) {
 	return goValue, nil
 }
 func (cf *ColumnFamily) Delete(key []byte) error {
+	if e := cf.use(); e != nil {
+		return e
+	}
+	defer cf.done()
 	cKey, keyLen := toCBytes(key)
 	var err *C.char
 	C.rocksdb_delete_cf(cf.rocks.db, cf.rocks.wo, cf.handle, cKey, keyLen, &err)
@@ -67,6 +107,10 @@ func (cf *ColumnFamily) PutBatch(keys, values [][]byte) error {
 	if len(keys) != len(values) {
 		return errors.New("keys and values must correspond one to one")
 	}
+	if e := cf.use(); e != nil {
+		return e
+	}
+	defer cf.done()
 	wb := C.rocksdb_writebatch_create()
 	defer C.rocksdb_writebatch_destroy(wb)
 	count := len(keys)
@@ -86,6 +130,10 @@ func (cf *ColumnFamily) DeleteBatch(keys [][]byte) error {
 	if keys == nil {
 		return errKeyIsNil
 	}
+	if e := cf.use(); e != nil {
+		return e
+	}
+	defer cf.done()
 	wb := C.rocksdb_writebatch_create()
 	defer C.rocksdb_writebatch_destroy(wb)
 	for _, key := range keys {
@@ -102,6 +150,10 @@ func (cf *ColumnFamily) DeleteBatch(keys [][]byte) error {
 // nil 和 0 字节的有效指针效果是一样的，函数删除时匹配 start，但是不匹配 end，也就是和
 // start 相同的键会被删除，但是和 end 相同的键会被保留，只删除 end 之前的键。
 func (cf *ColumnFamily) DeleteRange(start, end []byte) error {
+	if e := cf.use(); e != nil {
+		return e
+	}
+	defer cf.done()
 	cStart, startLen := toCBytes(start)
 	cEnd, endLen := toCBytes(end)
 	var err *C.char
@@ -123,6 +175,10 @@ func (cf *ColumnFamily) GetMulti(keys [][]byte, cb func(key, val []byte)) error
 	if numKey == 0 {
 		return nil
 	}
+	if e := cf.use(); e != nil {
+		return e
+	}
+	defer cf.done()
 
 	// 使用 C 内存分配数组
 	cKeys := (**C.char)(C.malloc(C.size_t(numKey) * C.size_t(unsafe.Sizeof((*C.char)(nil)))))
@@ -174,6 +230,10 @@ func (cf *ColumnFamily) GetMulti(keys [][]byte, cb func(key, val []byte)) error
 	return err
 }
 func (cf *ColumnFamily) DeletePrefix(prefix []byte) (int, error) {
+	if e := cf.use(); e != nil {
+		return 0, e
+	}
+	defer cf.done()
 	iter := C.rocksdb_create_iterator_cf(cf.rocks.db, cf.rocks.ro, cf.handle)
 	defer C.rocksdb_iter_destroy(iter)
 	cPrefix, pfLen := toCBytes(prefix)
@@ -205,11 +265,16 @@ func (cf *ColumnFamily) DeletePrefix(prefix []byte) (int, error) {
 	return count, charErr(err)
 }
 
-// ListPrefix 列出指定前缀的项，返回 false 终止，如何要列出全部项，传入一个长度为 0 的 prefix，但是不能是 nil，防止误操作
-func (cf *ColumnFamily) ListPrefix(prefix []byte, cb func(key, val []byte) bool) {
+// ListPrefix 列出指定前缀的项，返回 false 终止，如何要列出全部项，传入一个长度为 0 的 prefix，但是不能是 nil，防止误操作。
+// 遍历期间关闭数据库会等待遍历结束，回调中调用已经开始关闭的对象的方法会得到 ErrClosed
+func (cf *ColumnFamily) ListPrefix(prefix []byte, cb func(key, val []byte) bool) error {
 	if cb == nil {
-		return
+		return errProcIsNil
+	}
+	if e := cf.use(); e != nil {
+		return e
 	}
+	defer cf.done()
 	iter := C.rocksdb_create_iterator_cf(cf.rocks.db, cf.rocks.ro, cf.handle)
 	defer C.rocksdb_iter_destroy(iter)
 	cPrefix, pfLen := toCBytes(prefix)
@@ -239,14 +304,21 @@ func (cf *ColumnFamily) ListPrefix(prefix []byte, cb func(key, val []byte) bool)
 
 		C.rocksdb_iter_next(iter)
 	}
+	var err *C.char
+	C.rocksdb_iter_get_error(iter, &err)
+	return charErr(err)
 }
 
 // ListRange 列出指定范围的键值对, key == start, 在范围内，key == end 不在范围内
 // start 和 end 长度都为 0 时，不会返回全部条目，遍历全部键使用 ListPrefix(nil,cb)
-func (cf *ColumnFamily) ListRange(start, end []byte, cb func(key, val []byte) bool) {
+func (cf *ColumnFamily) ListRange(start, end []byte, cb func(key, val []byte) bool) error {
 	if cb == nil {
-		return
+		return errProcIsNil
 	}
+	if e := cf.use(); e != nil {
+		return e
+	}
+	defer cf.done()
 	iter := C.rocksdb_create_iterator_cf(cf.rocks.db, cf.rocks.ro, cf.handle)
 	defer C.rocksdb_iter_destroy(iter)
 	cPrefix, pfLen := toCBytes(start)
@@ -276,4 +348,7 @@ func (cf *ColumnFamily) ListRange(start, end []byte, cb func(key, val []byte) bo
 
 		C.rocksdb_iter_next(iter)
 	}
+	var err *C.char
+	C.rocksdb_iter_get_error(iter, &err)
+	return charErr(err)
 }
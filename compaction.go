@@ -0,0 +1,144 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import "time"
+
+// BottommostLevelCompaction 手动压缩时最底层文件的处理方式
+type BottommostLevelCompaction int
+
+const (
+	// BottommostDefault 使用 rocksdb 的默认行为，等同于 BottommostIfHaveCompactionFilter
+	BottommostDefault BottommostLevelCompaction = iota
+	// BottommostSkip 跳过最底层
+	BottommostSkip
+	// BottommostIfHaveCompactionFilter 只有设置了 compaction filter 才压缩最底层
+	BottommostIfHaveCompactionFilter
+	// BottommostForce 总是压缩最底层，删除标记会在这一步被真正清除
+	BottommostForce
+	// BottommostForceOptimized 和 BottommostForce 相同，但是跳过本次压缩刚生成的文件
+	BottommostForceOptimized
+)
+
+// CompactOptions 手动压缩的选项，零值表示使用 rocksdb 的默认设置
+type CompactOptions struct {
+	// ExclusiveManual 为 true 时，手动压缩期间不会运行自动压缩
+	ExclusiveManual bool
+	// ChangeLevel 为 true 时，压缩后的文件移动到 TargetLevel
+	ChangeLevel bool
+	// TargetLevel 只在 ChangeLevel 为 true 时有效，-1 表示能容纳数据的最小层级
+	TargetLevel int
+	// BottommostLevelCompaction 最底层的处理方式，清理删除标记时使用 BottommostForce
+	BottommostLevelCompaction BottommostLevelCompaction
+}
+
+// WaitForCompactOptions WaitForCompact 的选项
+type WaitForCompactOptions struct {
+	// AbortOnPause 为 true 时，如果后台任务被暂停，立即返回错误而不是一直等待
+	AbortOnPause bool
+	// FlushFirst 为 true 时，等待前先刷写所有 MemTable
+	FlushFirst bool
+	// Timeout 最长等待时间，0 表示不限制，超时返回错误
+	Timeout time.Duration
+}
+
+// CompactRange 手动压缩 [start, end] 范围的数据（rocksdb 的 CompactRange 包含 end），start 或者 end 为空表示不限制这一端，都为空时压缩全部数据。
+// 大量 DeletePrefix 或 DeleteRange 后调用，可以回收磁盘空间并清除影响范围扫描的删除标记。
+func (cf *ColumnFamily) CompactRange(start, end []byte, opts CompactOptions) error {
+	if e := cf.use(); e != nil {
+		return e
+	}
+	defer cf.done()
+	cOpts := C.rocksdb_compactoptions_create()
+	defer C.rocksdb_compactoptions_destroy(cOpts)
+	C.rocksdb_compactoptions_set_exclusive_manual_compaction(cOpts, boolToUChar(opts.ExclusiveManual))
+	if opts.ChangeLevel {
+		C.rocksdb_compactoptions_set_change_level(cOpts, 1)
+		C.rocksdb_compactoptions_set_target_level(cOpts, C.int(opts.TargetLevel))
+	}
+	if opts.BottommostLevelCompaction != BottommostDefault {
+		//rocksdb 的枚举从 kSkip = 0 开始
+		C.rocksdb_compactoptions_set_bottommost_level_compaction(cOpts, C.uchar(opts.BottommostLevelCompaction-1))
+	}
+
+	cStart, startLen := toCRangeKey(start)
+	cEnd, endLen := toCRangeKey(end)
+	//void rocksdb_compact_range_cf_opt(rocksdb_t* db, rocksdb_column_family_handle_t* column_family,
+	//    rocksdb_compactoptions_t* opt, const char* start_key, size_t start_key_len,
+	//    const char* limit_key, size_t limit_key_len);
+	C.rocksdb_compact_range_cf_opt(cf.rocks.db, cf.handle, cOpts, cStart, startLen, cEnd, endLen)
+	return nil
+}
+
+// Flush 把 MemTable 刷写到 SST 文件，cfs 为空时刷写全部 column family，wait 为 true 时等待刷写完成
+func (rdb *Db) Flush(cfs []*ColumnFamily, wait bool) error {
+	if len(cfs) == 0 {
+		rdb.mut.Lock()
+		cfs = rdb.cfList.Values()
+		rdb.mut.Unlock()
+	}
+	def, e := rdb.useDefault()
+	if e != nil {
+		return e
+	}
+	defer def.done()
+	handles := make([]*C.rocksdb_column_family_handle_t, 0, len(cfs))
+	for _, cf := range cfs {
+		//已经删除的 column family 跳过
+		if cf.use() != nil {
+			continue
+		}
+		defer cf.done()
+		handles = append(handles, cf.handle)
+	}
+	if len(handles) == 0 {
+		return nil
+	}
+	fOpts := C.rocksdb_flushoptions_create()
+	defer C.rocksdb_flushoptions_destroy(fOpts)
+	C.rocksdb_flushoptions_set_wait(fOpts, boolToUChar(wait))
+
+	var err *C.char
+	C.rocksdb_flush_cfs(def.rocks.db, fOpts, &handles[0], C.int(len(handles)), &err)
+	return charErr(err)
+}
+
+// FlushWAL 把 WAL 缓冲写入文件，sync 为 true 时同时同步到磁盘
+func (rdb *Db) FlushWAL(sync bool) error {
+	def, e := rdb.useDefault()
+	if e != nil {
+		return e
+	}
+	defer def.done()
+	var err *C.char
+	C.rocksdb_flush_wal(def.rocks.db, boolToUChar(sync), &err)
+	return charErr(err)
+}
+
+// WaitForCompact 等待所有后台刷写和压缩任务完成
+func (rdb *Db) WaitForCompact(opts WaitForCompactOptions) error {
+	def, e := rdb.useDefault()
+	if e != nil {
+		return e
+	}
+	defer def.done()
+	cOpts := C.rocksdb_wait_for_compact_options_create()
+	defer C.rocksdb_wait_for_compact_options_destroy(cOpts)
+	C.rocksdb_wait_for_compact_options_set_abort_on_pause(cOpts, boolToUChar(opts.AbortOnPause))
+	C.rocksdb_wait_for_compact_options_set_flush(cOpts, boolToUChar(opts.FlushFirst))
+	if opts.Timeout > 0 {
+		C.rocksdb_wait_for_compact_options_set_timeout(cOpts, C.uint64_t(opts.Timeout.Microseconds()))
+	}
+
+	var err *C.char
+	C.rocksdb_wait_for_compact(def.rocks.db, cOpts, &err)
+	return charErr(err)
+}
@@ -13,6 +13,8 @@ import "C"
 import (
 	"errors"
 	"github.com/jsuserapp/ju"
+	"path/filepath"
+	"slices"
 	"sync"
 	"unsafe"
 )
@@ -21,11 +23,36 @@ type dbType struct {
 	db *C.rocksdb_t
 	wo *C.rocksdb_writeoptions_t
 	ro *C.rocksdb_readoptions_t
+	//live 所有 column family 的操作都持有它，Db.Close 等待它们结束后才关闭数据库
+	live liveness
 }
 
 var errKeyIsNil = errors.New("key Can't be nil")
 var errProcIsNil = errors.New("call back function cannot be nil")
-var errHandleIsNil = errors.New("handle is nil, it has closed or not inited")
+var errDbIsOpened = errors.New("database is opened by this process, close it first")
+
+// ErrNotSupported rocksdb 的 C 接口没有提供这个功能
+var ErrNotSupported = errors.New("not supported by the rocksdb C API")
+
+// openedPaths 当前进程中已经打开的数据库路径
+var openedPaths = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: map[string]bool{}}
+
+// cleanDbPath 把数据库路径转为绝对路径，同一个目录的不同写法得到相同的结果
+func cleanDbPath(path string) string {
+	abs, e := filepath.Abs(path)
+	if e != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
+func isPathOpened(path string) bool {
+	openedPaths.Lock()
+	defer openedPaths.Unlock()
+	return openedPaths.paths[cleanDbPath(path)]
+}
 
 // SetErrLang rocksdb 返回的错误字符串编码是当前运行环境的语言编码相关的，必然运行环境是中文GBK，
 // 则需要相应的转码才能正确显示内容。鉴于语言编码众多，用户自行设置转码操作。如果不设置这个函数，默认
@@ -37,16 +64,47 @@ func SetErrLang(errLangString func(err []byte) string) {
 type Db struct {
 	mut    sync.Mutex
 	cfList *ju.OrderMap[string, *ColumnFamily]
+	//opts 打开数据库时使用的选项副本，Statistics 等共享对象通过它访问
+	opts *C.rocksdb_options_t
+	//path 数据库的绝对路径
+	path string
+	//disableWAL 写入不经过 WAL，关闭前没有刷写的数据会丢失
+	disableWAL bool
+	//undeclaredCfs OpenColumnFamilies 打开时磁盘上存在但是没有声明的 column family
+	undeclaredCfs []string
+	//sharedKey 非空时数据库由 OpenShared 打开并登记在 sharedDbs 中
+	sharedKey string
+}
+
+// ColumnFamilyDescriptor 指定 column family 的名称和它使用的选项，Options 为 nil 时使用数据库的选项
+type ColumnFamilyDescriptor struct {
+	Name    string
+	Options *Options
 }
 
-func Open(path string, opts *Options) (*Db, error) {
-	//尝试创建数据库，因为后面的操作需要数据库必须存在。
-	//如果数据库已经存在，这个操作可能会打开失败，忽略它。
+// Open 打开数据库和全部已经存在的 column family，descs 可以为指定的 column family 设置单独的选项，
+// descs 中不存在的 column family 会被创建，没有在 descs 中列出的 column family 使用 opts。
+// CreateColumnFamiliesIfMissing 为 false 时，descs 中不存在的 column family 返回 *ColumnFamilyMismatchError，不打开数据库
+func Open(path string, opts *Options, descs ...ColumnFamilyDescriptor) (*Db, error) {
 	if opts == nil {
 		opts = GetDefaultOptions()
 		defer opts.Close()
 	}
-	tryCreateDb(path, opts)
+	//把 Go 字段写入 C 选项，无效的选项在打开前就返回错误
+	if e := opts.Set(); e != nil {
+		return nil, e
+	}
+	for _, desc := range descs {
+		if desc.Options == nil {
+			continue
+		}
+		if e := desc.Options.Set(); e != nil {
+			return nil, e
+		}
+	}
+	if isPathOpened(path) {
+		return nil, errDbIsOpened
+	}
 
 	cfs := ju.NewOrderMap[string, *ColumnFamily]()
 	dbcf := &Db{cfList: cfs}
@@ -59,20 +117,53 @@ func Open(path string, opts *Options) (*Db, error) {
 	//获取已经存在的 column family
 	existNames, e := getExistCfNames(opts.handle, dbPath)
 	if e != nil {
-		return nil, e
+		//数据库还不存在时只打开 default，由 create_if_missing 创建数据库
+		if !opts.CreateIfMissing || dbExists(path) {
+			return nil, e
+		}
+		existNames = map[string]bool{"default": true}
+	}
+	if !opts.CreateColumnFamiliesIfMissing {
+		mismatch := &ColumnFamilyMismatchError{}
+		for _, desc := range descs {
+			if desc.Name != "" && !existNames[desc.Name] && !slices.Contains(mismatch.Missing, desc.Name) {
+				mismatch.Missing = append(mismatch.Missing, desc.Name)
+			}
+		}
+		if len(mismatch.Missing) > 0 {
+			return nil, mismatch
+		}
 	}
 	for existName := range existNames {
 		dbcf.cfList.Set(existName, nil)
 	}
-	e = dbcf.openExistCf(opts.handle, dbPath, existNames)
+	e = dbcf.openExistCf(opts.handle, dbPath, existNames, descs)
 	if e != nil {
-		return nil, e
+		return nil, wrapLockErr(e)
 	}
 	dbcf.initDb(opts)
+	dbcf.setOpened(path, opts.handle)
+	//生成 descs 中还不存在的 column family
+	dbcf.mut.Lock()
+	_, e = dbcf.createDescCf(opts, descs)
+	dbcf.mut.Unlock()
+	if e != nil {
+		dbcf.Close()
+		return nil, e
+	}
 
 	return dbcf, nil
 }
 
+// setOpened 保存打开数据库时的选项，并把路径登记为已打开
+func (rdb *Db) setOpened(path string, opts *C.rocksdb_options_t) {
+	rdb.opts = C.rocksdb_options_create_copy(opts)
+	rdb.path = cleanDbPath(path)
+	openedPaths.Lock()
+	openedPaths.paths[rdb.path] = true
+	openedPaths.Unlock()
+}
+
 func (rdb *Db) GetColumnFamily(name string) *ColumnFamily {
 	rdb.mut.Lock()
 	defer rdb.mut.Unlock()
@@ -82,46 +173,88 @@ func (rdb *Db) GetColumnFamily(name string) *ColumnFamily {
 func (rdb *Db) GetDefault() *ColumnFamily {
 	rdb.mut.Lock()
 	defer rdb.mut.Unlock()
+	return rdb.defaultCf()
+}
+
+// defaultCf 调用者必须持有 rdb.mut
+func (rdb *Db) defaultCf() *ColumnFamily {
 	cf, _ := rdb.cfList.Get("default")
 	return cf
 }
+
+// Close 关闭数据库，先拒绝新的操作并等待进行中的操作和遍历结束，然后释放全部 C 资源，
+// 之后 ColumnFamily 的方法返回 ErrClosed。重复调用没有作用。
+// OpenShared 打开的数据库只能通过 SharedDb.Close 关闭，直接调用这个方法没有作用
 func (rdb *Db) Close() {
+	if rdb.sharedKey != "" {
+		return
+	}
+	rdb.close()
+}
+
+// close 关闭数据库并释放 C 资源
+func (rdb *Db) close() {
+	def := rdb.GetDefault()
+	if def == nil || def.rocks == nil || !def.rocks.live.shutdown() {
+		return
+	}
 	rdb.mut.Lock()
 	defer rdb.mut.Unlock()
 	for _, cf := range rdb.cfList.Values() {
 		cf.Close()
 	}
-	cf, _ := rdb.cfList.Get("default")
-	if cf != nil && cf.rocks != nil {
-		rocks := cf.rocks
-		if rocks.wo != nil {
-			C.rocksdb_writeoptions_destroy(rocks.wo)
-			rocks.wo = nil
-		}
-		if rocks.ro != nil {
-			C.rocksdb_readoptions_destroy(rocks.ro)
-			rocks.ro = nil
-		}
-		if rocks.db != nil {
-			C.rocksdb_close(rocks.db)
-			rocks.db = nil
-		}
+	rocks := def.rocks
+	if rocks.wo != nil {
+		C.rocksdb_writeoptions_destroy(rocks.wo)
+		rocks.wo = nil
+	}
+	if rocks.ro != nil {
+		C.rocksdb_readoptions_destroy(rocks.ro)
+		rocks.ro = nil
+	}
+	if rocks.db != nil {
+		C.rocksdb_close(rocks.db)
+		rocks.db = nil
+	}
+	if rdb.opts != nil {
+		C.rocksdb_options_destroy(rdb.opts)
+		rdb.opts = nil
+	}
+	if rdb.path != "" {
+		openedPaths.Lock()
+		delete(openedPaths.paths, rdb.path)
+		openedPaths.Unlock()
+		rdb.path = ""
 	}
 }
+
+// DeleteColumnFamily 删除 column family 和它的全部数据，进行中的操作结束后释放它的句柄，
+// 之后这个 ColumnFamily 的方法返回 ErrClosed
 func (rdb *Db) DeleteColumnFamily(name string) (bool, error) {
 	rdb.mut.Lock()
-	defer rdb.mut.Unlock()
 	cf, _ := rdb.cfList.Get(name)
 	if cf == nil {
+		rdb.mut.Unlock()
 		return false, nil
 	}
+	if e := cf.use(); e != nil {
+		rdb.mut.Unlock()
+		return false, e
+	}
 	var err *C.char
 	C.rocksdb_drop_column_family(cf.rocks.db, cf.handle, &err)
 	if err != nil {
+		cf.done()
+		rdb.mut.Unlock()
 		return false, charErr(err)
 	}
 	rdb.cfList.Delete(name)
+	rdb.mut.Unlock()
+	//句柄必须在数据库关闭前释放，所以只结束 column family 的引用，保留数据库的引用直到 Close 结束。
+	//等待进行中的操作时不能持有 rdb.mut，它们的回调中可能调用 GetColumnFamily
+	cf.live.release()
 	cf.Close()
+	cf.rocks.live.release()
 	return true, nil
 }
 func (rdb *Db) ListColumnFamily() []string {
@@ -130,38 +263,124 @@ func (rdb *Db) ListColumnFamily() []string {
 	return rdb.cfList.Keys()
 }
 
-// AddColumnFamily 添加 column family，这个函数会先检测要添加的是否已经存在，如果已经存在，
-// 直接返回成功，不做任何更改
-func (rdb *Db) AddColumnFamily(addNames []string, opts *Options) bool {
+// ColumnFamilyOptions 返回 column family 实际使用的选项，column family 不存在时返回 nil，
+// 返回的 Options 持有独立的 C 资源，用完需要 Close
+func (rdb *Db) ColumnFamilyOptions(name string) *Options {
+	cf := rdb.GetColumnFamily(name)
+	if cf == nil {
+		return nil
+	}
+	return cf.Options()
+}
+
+// AddColumnFamily 添加 column family，已经存在的 column family 不做任何更改。addNames 使用 opts 创建，
+// descs 中的 column family 使用各自的选项创建。返回的 ColumnFamily 先按 addNames 再按 descs 的顺序排列，
+// 包含已经存在的，重复的名称只返回一次。可以和其他 column family 操作并发调用，
+// 出错时已经创建的 column family 会保留，可以用 GetColumnFamily 获取
+func (rdb *Db) AddColumnFamily(addNames []string, opts *Options, descs ...ColumnFamilyDescriptor) ([]*ColumnFamily, error) {
 	//检测名称的有效性和去重
 	addNames = uniqNames(addNames)
-	if len(addNames) > 0 {
-		var createNames []string
-		for _, name := range addNames {
-			_, ok := rdb.cfList.Get(name)
-			if !ok {
-				createNames = append(createNames, name)
-			}
+	if opts == nil {
+		opts = GetDefaultOptions()
+		defer opts.Close()
+	}
+	if e := opts.Set(); e != nil {
+		return nil, e
+	}
+
+	//检查和创建必须在同一个锁内，否则并发添加同名的 column family 会重复创建
+	rdb.mut.Lock()
+	defer rdb.mut.Unlock()
+	var createNames []string
+	for _, name := range addNames {
+		if _, ok := rdb.cfList.Get(name); !ok {
+			createNames = append(createNames, name)
 		}
+	}
+	//生成不存在的 column family
+	if e := rdb.createCf(opts.handle, createNames); e != nil {
+		return nil, e
+	}
+	cfs := make([]*ColumnFamily, 0, len(addNames)+len(descs))
+	for _, name := range addNames {
+		cf, _ := rdb.cfList.Get(name)
+		cfs = append(cfs, cf)
+	}
+	descCfs, e := rdb.createDescCf(opts, descs)
+	if e != nil {
+		return nil, e
+	}
+	for _, cf := range descCfs {
+		if !slices.Contains(cfs, cf) {
+			cfs = append(cfs, cf)
+		}
+	}
+	return cfs, nil
+}
 
-		if opts == nil {
-			opts = GetDefaultOptions()
-			defer opts.Close()
+// createDescCf 逐个生成 descs 中不存在的 column family，Options 为 nil 的使用 opts，
+// 返回 descs 对应的 ColumnFamily，调用者必须持有 rdb.mut
+func (rdb *Db) createDescCf(opts *Options, descs []ColumnFamilyDescriptor) ([]*ColumnFamily, error) {
+	cfs := make([]*ColumnFamily, 0, len(descs))
+	for _, desc := range descs {
+		if desc.Name == "" {
+			continue
+		}
+		if cf, ok := rdb.cfList.Get(desc.Name); ok {
+			cfs = append(cfs, cf)
+			continue
+		}
+		cfOpts := desc.Options
+		if cfOpts == nil {
+			cfOpts = opts
 		}
-		//生成不存在的 column family
-		e := rdb.createCf(opts.handle, createNames)
-		if ju.CheckFailure(e) {
-			return false
+		if cfOpts == nil {
+			cfOpts = GetDefaultOptions()
+			defer cfOpts.Close()
 		}
+		if e := cfOpts.Set(); e != nil {
+			return nil, e
+		}
+		if e := rdb.createCf(cfOpts.handle, []string{desc.Name}); e != nil {
+			return nil, e
+		}
+		cf, _ := rdb.cfList.Get(desc.Name)
+		cfs = append(cfs, cf)
 	}
-	return true
+	return cfs, nil
 }
-func (rdb *Db) openExistCf(opts *C.rocksdb_options_t, dbPath *C.char, existNames map[string]bool) error {
+func (rdb *Db) openExistCf(opts *C.rocksdb_options_t, dbPath *C.char, existNames map[string]bool, descs []ColumnFamilyDescriptor) error {
 	count := len(existNames)
 	names := make([]string, 0, count)
 	for cfName := range existNames {
 		names = append(names, cfName)
 	}
+	descOpts := map[string]*C.rocksdb_options_t{}
+	for _, desc := range descs {
+		if desc.Options != nil {
+			descOpts[desc.Name] = desc.Options.handle
+		}
+	}
+	cfOpts := make([]*C.rocksdb_options_t, count)
+	for i, name := range names {
+		if handle, ok := descOpts[name]; ok {
+			cfOpts[i] = C.rocksdb_options_create_copy(handle)
+		} else {
+			cfOpts[i] = C.rocksdb_options_create_copy(opts)
+		}
+	}
+	defer func() {
+		for i := range cfOpts {
+			C.rocksdb_options_destroy(cfOpts[i])
+		}
+	}()
+	return rdb.openCf(opts, dbPath, names, cfOpts)
+}
+
+// openCf 打开数据库和 names 中的全部 column family，cfOpts 和 names 一一对应，
+// 函数不会释放 cfOpts，每个 ColumnFamily 保存自己的选项副本
+func (rdb *Db) openCf(opts *C.rocksdb_options_t, dbPath *C.char, names []string, cfOpts []*C.rocksdb_options_t) error {
+	count := len(names)
 	//打开 column family
 	cfNamesC := make([]*C.char, count)
 	for i, cfNameC := range names {
@@ -174,11 +393,6 @@ func (rdb *Db) openExistCf(opts *C.rocksdb_options_t, dbPath *C.char, existNames
 	}()
 	cfHandles := make([]*C.rocksdb_column_family_handle_t, count)
 
-	cfOpts := make([]*C.rocksdb_options_t, count)
-	for i := range cfOpts {
-		cfOpts[i] = C.rocksdb_options_create_copy(opts)
-	}
-
 	var err *C.char
 	//rocksdb_t* rocksdb_open_column_families(
 	//    const rocksdb_options_t* options, const char* name, int num_column_families,
@@ -186,9 +400,6 @@ func (rdb *Db) openExistCf(opts *C.rocksdb_options_t, dbPath *C.char, existNames
 	//    const rocksdb_options_t* const* column_family_options,
 	//    rocksdb_column_family_handle_t** column_family_handles, char** errptr);
 	handle := C.rocksdb_open_column_families(opts, dbPath, C.int(count), &cfNamesC[0], &cfOpts[0], &cfHandles[0], &err)
-	for i := range cfOpts {
-		C.rocksdb_options_destroy(cfOpts[i])
-	}
 	if err != nil {
 		return charErr(err)
 	}
@@ -200,12 +411,13 @@ func (rdb *Db) openExistCf(opts *C.rocksdb_options_t, dbPath *C.char, existNames
 		rdb.cfList.Set(name, &ColumnFamily{
 			rocks:  rocks,
 			handle: cfHandles[i],
+			opts:   C.rocksdb_options_create_copy(cfOpts[i]),
 		})
 	}
 	return nil
 }
 
-// createCf 数据库必须已经打开，default 必然存在
+// createCf 数据库必须已经打开，default 必然存在，调用者必须持有 rdb.mut
 func (rdb *Db) createCf(opts *C.rocksdb_options_t, createNames []string) error {
 	createCount := len(createNames)
 	if createCount == 0 {
@@ -226,7 +438,12 @@ func (rdb *Db) createCf(opts *C.rocksdb_options_t, createNames []string) error {
 	var err *C.char
 	var lencfs C.size_t
 
-	rocks := rdb.GetDefault().rocks
+	def := rdb.defaultCf()
+	if e := def.use(); e != nil {
+		return e
+	}
+	defer def.done()
+	rocks := def.rocks
 	handleList := C.rocksdb_create_column_families(rocks.db, opts, C.int(createCount), &createNamesC[0], &lencfs, &err)
 	if err != nil {
 		return charErr(err)
@@ -239,6 +456,7 @@ func (rdb *Db) createCf(opts *C.rocksdb_options_t, createNames []string) error {
 		rdb.cfList.Set(createNames[i], &ColumnFamily{
 			rocks:  rocks,
 			handle: handles[i],
+			opts:   C.rocksdb_options_create_copy(opts),
 		})
 	}
 	return nil
@@ -248,6 +466,7 @@ func (rdb *Db) initDb(options *Options) {
 	//所有cf共享同一个rocks
 	rocks.wo = C.rocksdb_writeoptions_create()
 	if options != nil && options.DisableWAL {
+		rdb.disableWAL = true
 		C.rocksdb_writeoptions_disable_WAL(rocks.wo, C.int(boolToCint(options.DisableWAL)))
 	}
 	rocks.ro = C.rocksdb_readoptions_create()
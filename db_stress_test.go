@@ -0,0 +1,60 @@
+package rocksdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestColumnFamilyConcurrency 并发地添加、删除和读写 column family，用 go test -race 运行检查数据竞争。
+// 读写被删除的 column family 只允许返回 ErrClosed
+func TestColumnFamilyConcurrency(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const workers = 8
+	const rounds = 200
+	names := []string{"s0", "s1", "s2", "s3"}
+	var wg sync.WaitGroup
+	fail := func(err error) {
+		if err != nil && !errors.Is(err, ErrClosed) {
+			t.Error(err)
+		}
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				name := names[(w+i)%len(names)]
+				key := []byte(fmt.Sprintf("%d-%d", w, i))
+				switch i % 4 {
+				case 0:
+					_, err := db.AddColumnFamily([]string{name}, nil)
+					fail(err)
+				case 1:
+					_, err := db.DeleteColumnFamily(name)
+					fail(err)
+				case 2:
+					if cf := db.GetColumnFamily(name); cf != nil {
+						fail(cf.Put(key, key))
+						_, err := cf.Get(key)
+						fail(err)
+					}
+				case 3:
+					if cf := db.GetColumnFamily(name); cf != nil {
+						fail(cf.ListPrefix(nil, func(key, val []byte) bool {
+							return true
+						}))
+					}
+					_ = db.ListColumnFamily()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,210 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// MutableOption 可以在数据库运行时修改的选项名称，和 rocksdb 选项字符串中的名称相同。
+// 它是 string 的别名，常量可以直接作为 SetOptions 的键，从配置文件读取的名称也不需要转换
+type MutableOption = string
+
+// column family 级别的可变选项，使用 ColumnFamily.SetOptions 或 Db.SetOptions 修改
+const (
+	OptWriteBufferSize                 MutableOption = "write_buffer_size"
+	OptMaxWriteBufferNumber            MutableOption = "max_write_buffer_number"
+	OptArenaBlockSize                  MutableOption = "arena_block_size"
+	OptDisableAutoCompactions          MutableOption = "disable_auto_compactions"
+	OptLevel0FileNumCompactionTrigger  MutableOption = "level0_file_num_compaction_trigger"
+	OptLevel0SlowdownWritesTrigger     MutableOption = "level0_slowdown_writes_trigger"
+	OptLevel0StopWritesTrigger         MutableOption = "level0_stop_writes_trigger"
+	OptTargetFileSizeBase              MutableOption = "target_file_size_base"
+	OptTargetFileSizeMultiplier        MutableOption = "target_file_size_multiplier"
+	OptMaxBytesForLevelBase            MutableOption = "max_bytes_for_level_base"
+	OptMaxBytesForLevelMultiplier      MutableOption = "max_bytes_for_level_multiplier"
+	OptMaxCompactionBytes              MutableOption = "max_compaction_bytes"
+	OptSoftPendingCompactionBytesLimit MutableOption = "soft_pending_compaction_bytes_limit"
+	OptHardPendingCompactionBytesLimit MutableOption = "hard_pending_compaction_bytes_limit"
+	OptCompression                     MutableOption = "compression"
+	OptTTL                             MutableOption = "ttl"
+	OptPeriodicCompactionSeconds       MutableOption = "periodic_compaction_seconds"
+	OptParanoidFileChecks              MutableOption = "paranoid_file_checks"
+	OptReportBgIoStats                 MutableOption = "report_bg_io_stats"
+	OptMaxSequentialSkipInIterations   MutableOption = "max_sequential_skip_in_iterations"
+	OptMemtablePrefixBloomSizeRatio    MutableOption = "memtable_prefix_bloom_size_ratio"
+)
+
+// 数据库级别的可变选项，使用 Db.SetDBOptions 修改
+const (
+	OptMaxBackgroundJobs              MutableOption = "max_background_jobs"
+	OptMaxBackgroundCompactions       MutableOption = "max_background_compactions"
+	OptMaxBackgroundFlushes           MutableOption = "max_background_flushes"
+	OptAvoidFlushDuringShutdown       MutableOption = "avoid_flush_during_shutdown"
+	OptDelayedWriteRate               MutableOption = "delayed_write_rate"
+	OptMaxTotalWalSize                MutableOption = "max_total_wal_size"
+	OptDeleteObsoleteFilesPeriodMicro MutableOption = "delete_obsolete_files_period_micros"
+	OptStatsDumpPeriodSec             MutableOption = "stats_dump_period_sec"
+	OptStatsPersistPeriodSec          MutableOption = "stats_persist_period_sec"
+	OptMaxOpenFiles                   MutableOption = "max_open_files"
+	OptBytesPerSync                   MutableOption = "bytes_per_sync"
+	OptWalBytesPerSync                MutableOption = "wal_bytes_per_sync"
+	OptCompactionReadaheadSize        MutableOption = "compaction_readahead_size"
+)
+
+var mutableCfOptions = map[MutableOption]bool{
+	OptWriteBufferSize: true, OptMaxWriteBufferNumber: true, OptArenaBlockSize: true,
+	OptDisableAutoCompactions: true, OptLevel0FileNumCompactionTrigger: true,
+	OptLevel0SlowdownWritesTrigger: true, OptLevel0StopWritesTrigger: true,
+	OptTargetFileSizeBase: true, OptTargetFileSizeMultiplier: true,
+	OptMaxBytesForLevelBase: true, OptMaxBytesForLevelMultiplier: true, OptMaxCompactionBytes: true,
+	OptSoftPendingCompactionBytesLimit: true, OptHardPendingCompactionBytesLimit: true,
+	OptCompression: true, OptTTL: true, OptPeriodicCompactionSeconds: true,
+	OptParanoidFileChecks: true, OptReportBgIoStats: true,
+	OptMaxSequentialSkipInIterations: true, OptMemtablePrefixBloomSizeRatio: true,
+}
+
+var mutableDbOptions = map[MutableOption]bool{
+	OptMaxBackgroundJobs: true, OptMaxBackgroundCompactions: true, OptMaxBackgroundFlushes: true,
+	OptAvoidFlushDuringShutdown: true, OptDelayedWriteRate: true, OptMaxTotalWalSize: true,
+	OptDeleteObsoleteFilesPeriodMicro: true, OptStatsDumpPeriodSec: true, OptStatsPersistPeriodSec: true,
+	OptMaxOpenFiles: true, OptBytesPerSync: true, OptWalBytesPerSync: true, OptCompactionReadaheadSize: true,
+}
+
+// SetOptions 修改 default column family 的可变选项，参考 ColumnFamily.SetOptions
+func (rdb *Db) SetOptions(opts map[string]string) error {
+	return rdb.GetDefault().SetOptions(opts)
+}
+
+// SetOptions 在运行时修改 column family 的可变选项，不需要重新打开数据库，值使用 rocksdb 选项字符串的格式，
+// 例如 {OptWriteBufferSize: "67108864", "disable_auto_compactions": "true"}。
+// 选项不在可变选项列表中时返回 *OptionError，修改成功后 ColumnFamily.Options 返回新的值。
+func (cf *ColumnFamily) SetOptions(opts map[string]string) error {
+	keys, values, e := checkMutableOptions(opts, mutableCfOptions)
+	if e != nil || len(keys) == 0 {
+		return e
+	}
+	if e := cf.use(); e != nil {
+		return e
+	}
+	defer cf.done()
+	cKeys, cValues := toCStrings(keys), toCStrings(values)
+	defer freeCStrings(cKeys)
+	defer freeCStrings(cValues)
+
+	var err *C.char
+	//void rocksdb_set_options_cf(rocksdb_t* db, rocksdb_column_family_handle_t* handle, int count,
+	//    const char* const keys[], const char* const values[], char** errptr);
+	C.rocksdb_set_options_cf(cf.rocks.db, cf.handle, C.int(len(keys)), &cKeys[0], &cValues[0], &err)
+	if err != nil {
+		return charErr(err)
+	}
+	cf.optsMut.Lock()
+	defer cf.optsMut.Unlock()
+	cf.opts = applyOptionsString(cf.opts, keys, values)
+	return nil
+}
+
+// SetDBOptions 在运行时修改数据库级别的可变选项，例如 max_background_jobs，修改成功后 Db.Options 返回新的值
+func (rdb *Db) SetDBOptions(opts map[string]string) error {
+	keys, values, e := checkMutableOptions(opts, mutableDbOptions)
+	if e != nil || len(keys) == 0 {
+		return e
+	}
+	cKeys, cValues := toCStrings(keys), toCStrings(values)
+	defer freeCStrings(cKeys)
+	defer freeCStrings(cValues)
+
+	def, e := rdb.useDefault()
+	if e != nil {
+		return e
+	}
+	var err *C.char
+	//void rocksdb_set_options(rocksdb_t* db, int count, const char* const keys[], const char* const values[], char** errptr);
+	C.rocksdb_set_options(def.rocks.db, C.int(len(keys)), &cKeys[0], &cValues[0], &err)
+	//先结束操作再获取 rdb.mut，Db.Close 等待操作结束时持有 rdb.mut
+	def.done()
+	if err != nil {
+		return charErr(err)
+	}
+	rdb.mut.Lock()
+	defer rdb.mut.Unlock()
+	rdb.opts = applyOptionsString(rdb.opts, keys, values)
+	return nil
+}
+
+// Options 返回数据库当前使用的数据库级别选项，返回的 Options 持有独立的 C 资源，用完需要 Close
+func (rdb *Db) Options() *Options {
+	rdb.mut.Lock()
+	defer rdb.mut.Unlock()
+	if rdb.opts == nil {
+		return nil
+	}
+	opt := &Options{handle: C.rocksdb_options_create_copy(rdb.opts)}
+	opt.Get()
+	return opt
+}
+
+// checkMutableOptions 检查选项是否都可以修改，返回按名称排序的键和值
+func checkMutableOptions(opts map[string]string, allowed map[MutableOption]bool) ([]string, []string, error) {
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		if !allowed[key] {
+			return nil, nil, &OptionError{Field: key, Value: opts[key], Reason: "not a mutable option at this level"}
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = opts[key]
+	}
+	return keys, values, nil
+}
+
+// applyOptionsString 把修改同步到保存的选项副本，返回新的副本并释放旧的。
+// 数据库已经接受了这些值，所以这里的解析不会失败，万一失败保留原来的副本。
+func applyOptionsString(base *C.rocksdb_options_t, keys, values []string) *C.rocksdb_options_t {
+	if base == nil {
+		return nil
+	}
+	items := make([]string, len(keys))
+	for i := range keys {
+		items[i] = keys[i] + "=" + values[i]
+	}
+	cStr := C.CString(strings.Join(items, ";"))
+	defer C.free(unsafe.Pointer(cStr))
+	newOpts := C.rocksdb_options_create()
+	var err *C.char
+	C.rocksdb_get_options_from_string(base, cStr, newOpts, &err)
+	if err != nil {
+		C.free(unsafe.Pointer(err))
+		C.rocksdb_options_destroy(newOpts)
+		return base
+	}
+	C.rocksdb_options_destroy(base)
+	return newOpts
+}
+
+func toCStrings(strs []string) []*C.char {
+	cStrs := make([]*C.char, len(strs))
+	for i, str := range strs {
+		cStrs[i] = C.CString(str)
+	}
+	return cStrs
+}
+func freeCStrings(cStrs []*C.char) {
+	for _, cStr := range cStrs {
+		C.free(unsafe.Pointer(cStr))
+	}
+}
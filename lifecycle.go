@@ -0,0 +1,110 @@
+package rocksdb
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrClosed 数据库、column family 或者备份引擎已经关闭，column family 被删除后也返回这个错误。
+// 没有 error 返回值的查询方法（例如 Property、Metadata）在关闭后返回零值
+var ErrClosed = errors.New("rocksdb: closed")
+
+// liveness 记录正在使用 C 资源的操作数量。关闭时先拒绝新的操作，再等待进行中的操作结束，之后才能释放 C 资源。
+// 不使用 sync.RWMutex 是因为 ListPrefix 等方法的回调中可能再次调用同一个对象的方法，
+// 关闭等待期间 RWMutex 会阻塞这种嵌套的读锁，造成死锁；这里嵌套的调用会直接得到 ErrClosed
+type liveness struct {
+	mut    sync.Mutex
+	cond   *sync.Cond
+	refs   int
+	closed bool
+}
+
+// acquire 开始一个操作，已经关闭时返回 false
+func (l *liveness) acquire() bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.closed {
+		return false
+	}
+	l.refs++
+	return true
+}
+
+// release 结束 acquire 开始的操作
+func (l *liveness) release() {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.refs--
+	if l.refs == 0 && l.cond != nil {
+		l.cond.Broadcast()
+	}
+}
+
+// shutdown 拒绝新的操作并等待进行中的操作结束，已经关闭过时返回 false
+func (l *liveness) shutdown() bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.closed {
+		return false
+	}
+	l.closed = true
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mut)
+	}
+	for l.refs > 0 {
+		l.cond.Wait()
+	}
+	return true
+}
+
+// reopen 关闭后重新允许操作，只在 C 资源重新创建后调用
+func (l *liveness) reopen() {
+	l.mut.Lock()
+	l.closed = false
+	l.mut.Unlock()
+}
+
+// use 开始一个 column family 操作，column family 和数据库都必须没有关闭，成功时必须调用 done
+func (cf *ColumnFamily) use() error {
+	if cf == nil || !cf.live.acquire() {
+		return ErrClosed
+	}
+	if cf.rocks == nil || !cf.rocks.live.acquire() {
+		cf.live.release()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (cf *ColumnFamily) done() {
+	cf.rocks.live.release()
+	cf.live.release()
+}
+
+// useDefault 开始一个作用于整个数据库的操作，成功时必须调用返回值的 done
+func (rdb *Db) useDefault() (*ColumnFamily, error) {
+	cf := rdb.GetDefault()
+	if e := cf.use(); e != nil {
+		return nil, e
+	}
+	return cf, nil
+}
+
+// use 开始一个备份引擎操作，成功时必须调用 done
+func (be *BackupEngine) use() error {
+	be.swap.RLock()
+	if !be.live.acquire() {
+		be.swap.RUnlock()
+		return ErrClosed
+	}
+	if !be.readOnly && be.engine == nil {
+		be.done()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (be *BackupEngine) done() {
+	be.live.release()
+	be.swap.RUnlock()
+}
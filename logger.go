@@ -0,0 +1,104 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <stdint.h>
+#include <string.h>
+#include "c.h"
+
+extern void goRocksdbLog(void* priv, unsigned int lev, char* msg, size_t len);
+*/
+import "C"
+import (
+	"context"
+	"log/slog"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+)
+
+// rocksdb InfoLogLevel 的取值
+const (
+	rocksLogDebug = iota
+	rocksLogInfo
+	rocksLogWarn
+	rocksLogError
+	rocksLogFatal
+	rocksLogHeader
+)
+
+// logBridge 把 rocksdb 的日志转发给 slog.Logger
+type logBridge struct {
+	logger *slog.Logger
+}
+
+//export goRocksdbLog
+func goRocksdbLog(priv unsafe.Pointer, lev C.uint, msg *C.char, length C.size_t) {
+	//priv 为 NULL 的是 DisableLogFile 使用的丢弃日志
+	if priv == nil {
+		return
+	}
+	h := cgo.Handle(*(*C.uintptr_t)(priv))
+	bridge, ok := h.Value().(*logBridge)
+	if !ok {
+		return
+	}
+	text := strings.TrimRight(C.GoStringN(msg, C.int(length)), "\r\n")
+	bridge.logger.Log(context.Background(), rocksToSlogLevel(uint(lev)), text, slog.String("source", "rocksdb"))
+}
+
+func rocksToSlogLevel(lev uint) slog.Level {
+	switch lev {
+	case rocksLogDebug:
+		return slog.LevelDebug
+	case rocksLogWarn:
+		return slog.LevelWarn
+	case rocksLogError, rocksLogFatal:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func slogToRocksLevel(level slog.Level) C.int {
+	switch {
+	case level <= slog.LevelDebug:
+		return rocksLogDebug
+	case level <= slog.LevelInfo:
+		return rocksLogInfo
+	case level <= slog.LevelWarn:
+		return rocksLogWarn
+	default:
+		return rocksLogError
+	}
+}
+
+// SetLogger 把 rocksdb 的运行日志（刷写、压缩、写入停顿等）转发到 logger，level 以下的日志不会转发。
+// 设置后 rocksdb 不再在数据库目录中写 LOG 文件，KeepLogFileNum 也不再起作用。
+// StatsDumpPeriodSec 大于 0 时，定期输出的统计信息也会发送到 logger。
+// 每次调用会注册一个不会释放的桥接对象，因为 rocksdb 的后台线程可能在任何时候写日志，通常只需要在启动时调用一次。
+func (opt *Options) SetLogger(logger *slog.Logger, level slog.Level) {
+	opt.Create()
+	priv := (*C.uintptr_t)(C.malloc(C.size_t(unsafe.Sizeof(C.uintptr_t(0)))))
+	*priv = C.uintptr_t(cgo.NewHandle(&logBridge{logger: logger}))
+	rocksLevel := slogToRocksLevel(level)
+	//rocksdb_logger_t* rocksdb_logger_create_callback_logger(int log_level,
+	//    void (*)(void* priv, unsigned lev, char* msg, size_t len), void* priv);
+	cLogger := C.rocksdb_logger_create_callback_logger(rocksLevel, (*[0]byte)(C.goRocksdbLog), unsafe.Pointer(priv))
+	C.rocksdb_options_set_info_log(opt.handle, cLogger)
+	C.rocksdb_options_set_info_log_level(opt.handle, rocksLevel)
+	//选项持有 logger 的引用，包装对象可以释放
+	C.rocksdb_logger_destroy(cLogger)
+	opt.hasLogger = true
+}
+
+// setDiscardLogger 安装一个丢弃全部日志的 logger，rocksdb 就不会创建 LOG 文件
+func (opt *Options) setDiscardLogger() {
+	cLogger := C.rocksdb_logger_create_callback_logger(rocksLogHeader, (*[0]byte)(C.goRocksdbLog), nil)
+	C.rocksdb_options_set_info_log(opt.handle, cLogger)
+	C.rocksdb_logger_destroy(cLogger)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jsuserapp/ju"
+	"github.com/jsuserapp/rocksdb"
+)
+
+const (
+	testBackup1 = "./tmp/backup1"
+	testNewDb   = "./tmp/testnewdb"
+)
+
+func testBackup() {
+	opts := rocksdb.GetDefaultOptions()
+	db, err := rocksdb.Open(testDbPath, opts)
+	if ju.CheckFailure(err) {
+		return
+	}
+	defer db.Close()
+
+	ju.LogBlue(db.ListColumnFamily())
+
+	var be rocksdb.BackupEngine
+	if ju.CheckFailure(be.Open(testBackup1, nil)) {
+		return
+	}
+	defer be.Close()
+
+	//if ju.CheckFailure(be.CreateBackup(db)) {
+	//	return
+	//}
+	logBackupInfo(&be)
+
+	//if ju.CheckFailure(be.Restore(rocksdb.RestoreRequest{DbDir: testNewDb, BackupID: 1})) {
+	//	return
+	//}
+
+	newDb, err := rocksdb.Open(testNewDb, nil)
+	if ju.CheckFailure(err) {
+		return
+	}
+	defer newDb.Close()
+
+	cf := newDb.GetColumnFamily("tab1")
+	if cf == nil {
+		return
+	}
+	listCf(cf)
+}
+func logBackupInfo(be *rocksdb.BackupEngine) {
+	infos, err := be.GetInfo()
+	if ju.CheckFailure(err) {
+		return
+	}
+	for i, info := range infos {
+		tm := info.Timestamp.Format("2006-01-02 15:04:05.000")
+		ju.LogMagentaF("backup info: index=%d,id=%d,time=%s,size:%d,files:%d\n", i, info.ID, tm, info.Size, info.NumFiles)
+	}
+}
+func listCf(cf *rocksdb.ColumnFamily) {
+	cf.ListPrefix(nil, func(key, val []byte) bool {
+		ju.LogGreen(string(key), "=", string(val))
+		return true
+	})
+}
+func putData(cf *rocksdb.ColumnFamily) {
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("2%d", i)
+		val := fmt.Sprintf("v%d", i)
+		err := cf.Put([]byte(key), []byte(val))
+		if ju.CheckFailure(err) {
+			return
+		}
+	}
+}
+func restore() {
+	if ju.CheckFailure(rocksdb.RestoreBackup(testNewDb, testBackup1, 1)) {
+		return
+	}
+	checkDb(testNewDb)
+}
+func checkDb(dbPath string) {
+	db, e := rocksdb.Open(dbPath, nil)
+	if ju.CheckFailure(e) {
+		return
+	}
+	defer db.Close()
+	ju.LogGreen(db.ListColumnFamily())
+	cf := db.GetColumnFamily("tab1")
+	if cf == nil {
+		return
+	}
+	listCf(cf)
+}
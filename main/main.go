@@ -21,8 +21,9 @@ func main() {
 	//ju.LogGreen(ver)
 	//setErrLang()
 	//testRocks()
-	rocksdb.TestBackup()
+	testBackup()
 }
+
 func setErrLang() {
 	lang := os.Getenv("LANG")
 	if lang == "" {
@@ -196,7 +197,12 @@ func openCf(cfs []string) *rocksdb.Db {
 		ju.LogRed(err.Error())
 		return nil
 	}
-	db.AddColumnFamily(cfs, options)
+	_, err = db.AddColumnFamily(cfs, options)
+	if err != nil {
+		ju.LogRed(err.Error())
+		db.Close()
+		return nil
+	}
 	return db
 }
 func deleteCf() {
@@ -241,7 +247,11 @@ func addCf() {
 	}
 	defer dbcf.Close()
 
-	dbcf.AddColumnFamily(cfNames, options)
+	_, err = dbcf.AddColumnFamily(cfNames, options)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
 
 	cfList := dbcf.ListColumnFamily()
 	fmt.Println(cfList)
@@ -0,0 +1,163 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// RepairReport RepairDb 修复后数据库的情况
+type RepairReport struct {
+	// ColumnFamilies 修复后数据库中的 column family
+	ColumnFamilies []string
+	// TableFiles 修复后数据库目录中的 SST 文件数
+	TableFiles int
+	// TableBytes 修复后 SST 文件的总大小（字节）
+	TableBytes int64
+	// LostFiles 这次修复中 rocksdb 无法使用、移动到 lost 目录中的文件，相对于数据库目录，
+	// 以前的修复留在 lost 目录中的文件不包括在内
+	LostFiles []string
+}
+
+// DestroyDb 删除数据库的全部文件，数据库不能在当前进程中处于打开状态
+func DestroyDb(path string, opts *Options) error {
+	if isPathOpened(path) {
+		return errDbIsOpened
+	}
+	if opts == nil {
+		opts = GetDefaultOptions()
+		defer opts.Close()
+	}
+	if e := opts.Set(); e != nil {
+		return e
+	}
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	var err *C.char
+	//void rocksdb_destroy_db(const rocksdb_options_t* options, const char* name, char** errptr);
+	C.rocksdb_destroy_db(opts.handle, cPath, &err)
+	return charErr(err)
+}
+
+// RepairDb 尽可能恢复损坏的数据库，无法使用的文件会被移动到数据库目录下的 lost 目录。
+// 修复可能丢失数据，调用前最好先备份数据库目录。数据库不能在当前进程中处于打开状态。
+func RepairDb(path string, opts *Options) (*RepairReport, error) {
+	if isPathOpened(path) {
+		return nil, errDbIsOpened
+	}
+	if opts == nil {
+		opts = GetDefaultOptions()
+		defer opts.Close()
+	}
+	if e := opts.Set(); e != nil {
+		return nil, e
+	}
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	//修复前 lost 目录中已有的文件，报告中只列出这次新增的
+	lostBefore := map[string]bool{}
+	for _, file := range listLostFiles(path) {
+		lostBefore[file] = true
+	}
+	var err *C.char
+	//void rocksdb_repair_db(const rocksdb_options_t* options, const char* name, char** errptr);
+	C.rocksdb_repair_db(opts.handle, cPath, &err)
+	if err != nil {
+		return nil, charErr(err)
+	}
+	return getRepairReport(path, opts, cPath, lostBefore), nil
+}
+
+func getRepairReport(path string, opts *Options, cPath *C.char, lostBefore map[string]bool) *RepairReport {
+	report := &RepairReport{}
+	names, e := getExistCfNames(opts.handle, cPath)
+	if e == nil {
+		for name := range names {
+			report.ColumnFamilies = append(report.ColumnFamilies, name)
+		}
+		sort.Strings(report.ColumnFamilies)
+	}
+	entries, e := os.ReadDir(path)
+	if e == nil {
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sst") {
+				continue
+			}
+			report.TableFiles++
+			if info, e := entry.Info(); e == nil {
+				report.TableBytes += info.Size()
+			}
+		}
+	}
+	for _, file := range listLostFiles(path) {
+		if !lostBefore[file] {
+			report.LostFiles = append(report.LostFiles, file)
+		}
+	}
+	return report
+}
+
+// listLostFiles 返回数据库目录下 lost 目录中的全部文件，相对于数据库目录
+func listLostFiles(path string) []string {
+	var files []string
+	_ = filepath.WalkDir(filepath.Join(path, "lost"), func(file string, d os.DirEntry, e error) error {
+		if e != nil || d.IsDir() {
+			return nil
+		}
+		if rel, e := filepath.Rel(path, file); e == nil {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files
+}
+
+// VerifyChecksum 读取全部 column family 的所有数据块并校验 checksum，遇到第一个错误时返回。
+// rocksdb 的 C 接口没有提供 VerifyChecksum，这里通过开启校验的完整遍历实现，数据量大时耗时较长。
+func (rdb *Db) VerifyChecksum() error {
+	rdb.mut.Lock()
+	cfs := rdb.cfList.Values()
+	rdb.mut.Unlock()
+
+	ro := C.rocksdb_readoptions_create()
+	defer C.rocksdb_readoptions_destroy(ro)
+	C.rocksdb_readoptions_set_verify_checksums(ro, 1)
+	//校验时读取的数据块不放入缓存，避免影响正常读取
+	C.rocksdb_readoptions_set_fill_cache(ro, 0)
+
+	for _, cf := range cfs {
+		//已经删除的 column family 跳过，数据库关闭时返回 ErrClosed
+		if e := cf.use(); e != nil {
+			if cf.rocks.live.acquire() {
+				cf.rocks.live.release()
+				continue
+			}
+			return e
+		}
+		iter := C.rocksdb_create_iterator_cf(cf.rocks.db, ro, cf.handle)
+		for C.rocksdb_iter_seek_to_first(iter); C.rocksdb_iter_valid(iter) != 0; C.rocksdb_iter_next(iter) {
+			var valLen C.size_t
+			C.rocksdb_iter_value(iter, &valLen)
+		}
+		var err *C.char
+		C.rocksdb_iter_get_error(iter, &err)
+		C.rocksdb_iter_destroy(iter)
+		cf.done()
+		if err != nil {
+			return charErr(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import "unsafe"
+
+// LiveFile 描述数据库中一个正在使用的 SST 文件
+type LiveFile struct {
+	// ColumnFamily 文件所属的 column family 名称
+	ColumnFamily string
+	// Name 文件名，以 "/" 开头，相对于数据库目录
+	Name string
+	// Level 文件所在的层级
+	Level int
+	// Size 文件大小（字节）
+	Size uint64
+	// SmallestKey 和 LargestKey 是文件覆盖的键范围，两端都包含在内
+	SmallestKey []byte
+	LargestKey  []byte
+	// Entries 文件中的条目数，Deletions 其中删除标记的数量
+	Entries   uint64
+	Deletions uint64
+}
+
+// SstFileMetadata 描述 column family 某一层中的一个 SST 文件
+type SstFileMetadata struct {
+	// Name 文件名，相对于 Directory
+	Name      string
+	Directory string
+	Size      uint64
+	// SmallestKey 和 LargestKey 是文件覆盖的键范围，两端都包含在内
+	SmallestKey []byte
+	LargestKey  []byte
+}
+
+// LevelMetadata 描述 column family 某一层的文件情况
+type LevelMetadata struct {
+	Level int
+	// Size 这一层所有文件大小之和（字节）
+	Size  uint64
+	Files []SstFileMetadata
+}
+
+// ColumnFamilyMetadata 描述一个 column family 的文件分布
+type ColumnFamilyMetadata struct {
+	Name string
+	// Size 所有层文件大小之和（字节）
+	Size      uint64
+	FileCount int
+	Levels    []LevelMetadata
+}
+
+// LiveFiles 返回数据库当前使用的全部 SST 文件，包含所有 column family
+func (rdb *Db) LiveFiles() []LiveFile {
+	def, e := rdb.useDefault()
+	if e != nil {
+		return nil
+	}
+	defer def.done()
+	//const rocksdb_livefiles_t* rocksdb_livefiles(rocksdb_t* db);
+	lf := C.rocksdb_livefiles(def.rocks.db)
+	if lf == nil {
+		return nil
+	}
+	defer C.rocksdb_livefiles_destroy(lf)
+
+	count := int(C.rocksdb_livefiles_count(lf))
+	files := make([]LiveFile, count)
+	for i := 0; i < count; i++ {
+		idx := C.int(i)
+		var smallLen, largeLen C.size_t
+		smallKey := C.rocksdb_livefiles_smallestkey(lf, idx, &smallLen)
+		largeKey := C.rocksdb_livefiles_largestkey(lf, idx, &largeLen)
+		files[i] = LiveFile{
+			ColumnFamily: C.GoString(C.rocksdb_livefiles_column_family_name(lf, idx)),
+			Name:         C.GoString(C.rocksdb_livefiles_name(lf, idx)),
+			Level:        int(C.rocksdb_livefiles_level(lf, idx)),
+			Size:         uint64(C.rocksdb_livefiles_size(lf, idx)),
+			SmallestKey:  C.GoBytes(unsafe.Pointer(smallKey), C.int(smallLen)),
+			LargestKey:   C.GoBytes(unsafe.Pointer(largeKey), C.int(largeLen)),
+			Entries:      uint64(C.rocksdb_livefiles_entries(lf, idx)),
+			Deletions:    uint64(C.rocksdb_livefiles_deletions(lf, idx)),
+		}
+	}
+	return files
+}
+
+// Metadata 返回 column family 每一层的文件列表和总大小
+func (cf *ColumnFamily) Metadata() *ColumnFamilyMetadata {
+	if e := cf.use(); e != nil {
+		return nil
+	}
+	defer cf.done()
+	cfMeta := C.rocksdb_get_column_family_metadata_cf(cf.rocks.db, cf.handle)
+	if cfMeta == nil {
+		return nil
+	}
+	defer C.rocksdb_column_family_metadata_destroy(cfMeta)
+
+	cName := C.rocksdb_column_family_metadata_get_name(cfMeta)
+	meta := &ColumnFamilyMetadata{
+		Name:      C.GoString(cName),
+		Size:      uint64(C.rocksdb_column_family_metadata_get_size(cfMeta)),
+		FileCount: int(C.rocksdb_column_family_metadata_get_file_count(cfMeta)),
+	}
+	C.rocksdb_free(unsafe.Pointer(cName))
+
+	levelCount := C.rocksdb_column_family_metadata_get_level_count(cfMeta)
+	meta.Levels = make([]LevelMetadata, 0, int(levelCount))
+	for i := C.size_t(0); i < levelCount; i++ {
+		levelMeta := C.rocksdb_column_family_metadata_get_level_metadata(cfMeta, i)
+		if levelMeta == nil {
+			continue
+		}
+		meta.Levels = append(meta.Levels, getLevelMetadata(levelMeta))
+		//子对象必须在父对象之前释放
+		C.rocksdb_level_metadata_destroy(levelMeta)
+	}
+	return meta
+}
+
+func getLevelMetadata(levelMeta *C.rocksdb_level_metadata_t) LevelMetadata {
+	fileCount := C.rocksdb_level_metadata_get_file_count(levelMeta)
+	level := LevelMetadata{
+		Level: int(C.rocksdb_level_metadata_get_level(levelMeta)),
+		Size:  uint64(C.rocksdb_level_metadata_get_size(levelMeta)),
+		Files: make([]SstFileMetadata, 0, int(fileCount)),
+	}
+	for i := C.size_t(0); i < fileCount; i++ {
+		fileMeta := C.rocksdb_level_metadata_get_sst_file_metadata(levelMeta, i)
+		if fileMeta == nil {
+			continue
+		}
+		cName := C.rocksdb_sst_file_metadata_get_relative_filename(fileMeta)
+		cDir := C.rocksdb_sst_file_metadata_get_directory(fileMeta)
+		var smallLen, largeLen C.size_t
+		smallKey := C.rocksdb_sst_file_metadata_get_smallestkey(fileMeta, &smallLen)
+		largeKey := C.rocksdb_sst_file_metadata_get_largestkey(fileMeta, &largeLen)
+		level.Files = append(level.Files, SstFileMetadata{
+			Name:        C.GoString(cName),
+			Directory:   C.GoString(cDir),
+			Size:        uint64(C.rocksdb_sst_file_metadata_get_size(fileMeta)),
+			SmallestKey: C.GoBytes(unsafe.Pointer(smallKey), C.int(smallLen)),
+			LargestKey:  C.GoBytes(unsafe.Pointer(largeKey), C.int(largeLen)),
+		})
+		C.rocksdb_free(unsafe.Pointer(cName))
+		C.rocksdb_free(unsafe.Pointer(cDir))
+		C.rocksdb_free(unsafe.Pointer(smallKey))
+		C.rocksdb_free(unsafe.Pointer(largeKey))
+		C.rocksdb_sst_file_metadata_destroy(fileMeta)
+	}
+	return level
+}
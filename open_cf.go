@@ -0,0 +1,143 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"github.com/jsuserapp/ju"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// ColumnFamilyMismatchError 磁盘上的 column family 和声明的不一致
+type ColumnFamilyMismatchError struct {
+	// Extra 磁盘上存在但是没有声明的 column family
+	Extra []string
+	// Missing 声明了但是磁盘上不存在的 column family
+	Missing []string
+}
+
+func (e *ColumnFamilyMismatchError) Error() string {
+	var parts []string
+	if len(e.Extra) > 0 {
+		parts = append(parts, "undeclared on disk: "+strings.Join(e.Extra, ", "))
+	}
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing on disk: "+strings.Join(e.Missing, ", "))
+	}
+	return "rocksdb column families mismatch, " + strings.Join(parts, "; ")
+}
+
+// OpenColumnFamilies 按声明的 column family 打开数据库，不存在的 column family 在打开时一次生成，
+// 数据库不存在并且 CreateIfMissing 为 true 时同时创建数据库。default 没有声明时使用 opts。
+// CreateColumnFamiliesIfMissing 为 false 时，声明的 column family 不存在会返回 *ColumnFamilyMismatchError，
+// 新数据库也是这样，这时只能声明 default。
+// 磁盘上没有声明的 column family 也必须打开（rocksdb 的要求），它们使用 opts，可以通过 Db.UndeclaredColumnFamilies 获取。
+// StrictColumnFamilies 为 true 时，已有数据库中的 column family 和声明的不完全相同（多出或者缺少）都返回
+// *ColumnFamilyMismatchError，不打开也不修改数据库；新数据库按声明创建全部 column family，不算不一致。
+func OpenColumnFamilies(path string, opts *Options, descs []ColumnFamilyDescriptor) (*Db, error) {
+	if opts == nil {
+		opts = GetDefaultOptions()
+		defer opts.Close()
+	}
+	if e := opts.Set(); e != nil {
+		return nil, e
+	}
+	declared := map[string]*Options{}
+	names := []string{"default"}
+	for _, desc := range descs {
+		if desc.Name == "" {
+			continue
+		}
+		if desc.Options != nil {
+			if e := desc.Options.Set(); e != nil {
+				return nil, e
+			}
+		}
+		if _, ok := declared[desc.Name]; !ok && desc.Name != "default" {
+			names = append(names, desc.Name)
+		}
+		declared[desc.Name] = desc.Options
+	}
+	if isPathOpened(path) {
+		return nil, errDbIsOpened
+	}
+
+	dbPath := C.CString(path)
+	defer C.free(unsafe.Pointer(dbPath))
+	existNames, e := getExistCfNames(opts.handle, dbPath)
+	if e != nil {
+		//数据库还不存在时由 rocksdb_open_column_families 创建
+		if !opts.CreateIfMissing || dbExists(path) {
+			return nil, e
+		}
+		existNames = map[string]bool{}
+	}
+
+	mismatch := &ColumnFamilyMismatchError{}
+	for name := range existNames {
+		if _, ok := declared[name]; !ok && name != "default" {
+			mismatch.Extra = append(mismatch.Extra, name)
+		}
+	}
+	for _, name := range names {
+		//新数据库中 default 由 rocksdb 创建
+		if !existNames[name] && (name != "default" || len(existNames) > 0) {
+			mismatch.Missing = append(mismatch.Missing, name)
+		}
+	}
+	sort.Strings(mismatch.Extra)
+	newDb := len(existNames) == 0
+	if (len(mismatch.Missing) > 0 && !opts.CreateColumnFamiliesIfMissing) ||
+		(opts.StrictColumnFamilies && !newDb && (len(mismatch.Extra) > 0 || len(mismatch.Missing) > 0)) {
+		return nil, mismatch
+	}
+
+	//没有声明的 column family 排在最后，使用 opts 打开
+	names = append(names, mismatch.Extra...)
+	cfOpts := make([]*C.rocksdb_options_t, len(names))
+	for i, name := range names {
+		if cfOpt := declared[name]; cfOpt != nil {
+			cfOpts[i] = cfOpt.handle
+		} else {
+			cfOpts[i] = opts.handle
+		}
+	}
+	//一次打开时 rocksdb 需要 create_missing_column_families 才能创建声明的 column family
+	openOpts := C.rocksdb_options_create_copy(opts.handle)
+	defer C.rocksdb_options_destroy(openOpts)
+	C.rocksdb_options_set_create_missing_column_families(openOpts, 1)
+
+	rdb := &Db{cfList: ju.NewOrderMap[string, *ColumnFamily]()}
+	if e = rdb.openCf(openOpts, dbPath, names, cfOpts); e != nil {
+		return nil, wrapLockErr(e)
+	}
+	rdb.initDb(opts)
+	rdb.setOpened(path, opts.handle)
+	rdb.undeclaredCfs = mismatch.Extra
+	return rdb, nil
+}
+
+// UndeclaredColumnFamilies 返回 OpenColumnFamilies 打开时磁盘上存在但是没有声明的 column family，
+// 其他方式打开的数据库返回 nil
+func (rdb *Db) UndeclaredColumnFamilies() []string {
+	rdb.mut.Lock()
+	defer rdb.mut.Unlock()
+	return append([]string(nil), rdb.undeclaredCfs...)
+}
+
+// dbExists 数据库目录中是否已经有 CURRENT 文件
+func dbExists(path string) bool {
+	_, e := os.Stat(filepath.Join(path, "CURRENT"))
+	return e == nil
+}
@@ -10,6 +10,70 @@ package rocksdb
 #include "c.h"
 */
 import "C"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidOptions 所有选项错误都包装这个错误，可以用 errors.Is 判断
+var ErrInvalidOptions = errors.New("invalid rocksdb options")
+
+// OptionError 选项的值无效或者选项名称未知
+type OptionError struct {
+	// Field 选项名称
+	Field string
+	// Value 无效的值，未知选项时为空
+	Value string
+	// Reason 错误原因
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("rocksdb option %s: %s", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("rocksdb option %s=%q: %s", e.Field, e.Value, e.Reason)
+}
+func (e *OptionError) Unwrap() error {
+	return ErrInvalidOptions
+}
+
+// OptionConflictError 几个选项单独看都有效，但是组合在一起互相矛盾
+type OptionConflictError struct {
+	// Fields 互相矛盾的选项名称
+	Fields []string
+	// Reason 错误原因
+	Reason string
+}
+
+func (e *OptionConflictError) Error() string {
+	return fmt.Sprintf("rocksdb options %s conflict: %s", strings.Join(e.Fields, ", "), e.Reason)
+}
+func (e *OptionConflictError) Unwrap() error {
+	return ErrInvalidOptions
+}
+
+// compressionTypes CompressionType 支持的值和 rocksdb 的压缩类型
+var compressionTypes = map[string]C.int{
+	"none":   C.rocksdb_no_compression,
+	"snappy": C.rocksdb_snappy_compression,
+	"zlib":   C.rocksdb_zlib_compression,
+	"bzip2":  C.rocksdb_bz2_compression,
+	"lz4":    C.rocksdb_lz4_compression,
+	"lz4hc":  C.rocksdb_lz4hc_compression,
+	"zstd":   C.rocksdb_zstd_compression,
+}
+
+func compressionNames() string {
+	names := make([]string, 0, len(compressionTypes))
+	for name := range compressionTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
 
 // Options 定义 RocksDB 的数据库打开选项
 type Options struct {
@@ -17,63 +81,82 @@ type Options struct {
 	// CreateIfMissing 如果数据库不存在，是否创建新数据库
 	// 默认值: true
 	// 设为 false 时，若数据库不存在，打开会失败
-	CreateIfMissing bool
+	CreateIfMissing bool `json:"create_if_missing"`
 
-	//CreateColumnFamiliesIfMissing 打开数据库时自动生成不存在的 column families
-	//当前默认值 true，rocksdb 内部默认值是 false
-	CreateColumnFamiliesIfMissing bool
+	//CreateColumnFamiliesIfMissing 打开数据库时自动生成不存在的 column families，对 Open 和 OpenColumnFamilies 起作用，
+	//AddColumnFamily 总是生成。当前默认值 true，rocksdb 内部默认值是 false
+	CreateColumnFamiliesIfMissing bool `json:"create_missing_column_families"`
 
 	// IncreaseParallelism 增加后台线程的并行度，提升压缩和刷写性能
 	// 默认值: 0
 	// 通常设置为 CPU 核心数或稍低值，0 表示不调整
-	IncreaseParallelism int
+	IncreaseParallelism int `json:"increase_parallelism"`
 
 	// ErrorIfExists 如果数据库已存在，是否报错
 	// 默认值: false
 	// 设为 true 时，若数据库已存在，打开会失败
-	ErrorIfExists bool
+	ErrorIfExists bool `json:"error_if_exists"`
 
 	// WriteBufferSize MemTable 的大小（字节），影响内存使用和写性能
 	// 默认值: 0
 	// 增大可减少刷盘频率，但占用更多内存
-	WriteBufferSize int
+	WriteBufferSize int `json:"write_buffer_size"`
 
 	// MaxOpenFiles 最大打开文件数，影响文件句柄使用
 	// 默认值: 0
 	// 设为 -1 表示无限制，小值可能导致性能下降
-	MaxOpenFiles int
+	MaxOpenFiles int `json:"max_open_files"`
 
 	// DisableWAL 是否禁用 Write-Ahead Log（WAL）
 	// 默认值: false
 	// 设为 true 时，禁运 WAL 数据库降不会再记录写日志，但是数据库崩溃时，可能造成数据丢失。
-	DisableWAL bool
+	DisableWAL bool `json:"disable_wal"`
 
 	// CompressionType 数据压缩类型，影响存储空间和读写性能
-	// 默认值: "snappy" (支持: "none", "snappy", "zlib", "bzip2", "lz4", "zstd")
+	// 默认值: "snappy" (支持: "none", "snappy", "zlib", "bzip2", "lz4", "lz4hc", "zstd")
 	// "none" 表示无压缩，"snappy" 平衡速度和压缩率
-	CompressionType string
+	CompressionType string `json:"compression"`
 
 	// TargetFileSizeBase 每个 SST 文件的目标大小（字节）
 	// 默认值: 0
 	// 影响压缩和读取性能，小值增加文件数，大值减少文件数
-	TargetFileSizeBase uint64
+	TargetFileSizeBase uint64 `json:"target_file_size_base"`
 
 	// MaxBackgroundJobs 后台任务（如压缩、刷盘）的最大线程数
 	// 默认值: 0
 	// 增大可提升后台处理速度，但消耗更多 CPU
-	MaxBackgroundJobs int
+	MaxBackgroundJobs int `json:"max_background_jobs"`
 
 	// AllowConcurrentMemtableWrite 是否允许多线程并发写入 MemTable
 	// 默认值: false (RocksDB 6.7+ 支持)
 	// 设为 true 可提升多线程写性能
-	AllowConcurrentMemtableWrite bool
+	AllowConcurrentMemtableWrite bool `json:"allow_concurrent_memtable_write"`
 
 	//KeepLogFileNum 控制 RocksDB 操作日志（LOG 和 LOG.old.* 文件）的保留数量
-	KeepLogFileNum int
+	KeepLogFileNum int `json:"keep_log_file_num"`
 
 	//RecycleLogFileNum 控制 RocksDB 中 WAL 文件(.log)的回收数量，用于减少文件系统的创建和删除开销。
 	//默认值：0，表示不回收 WAL 文件，过期后直接删除。
-	RecycleLogFileNum int
+	RecycleLogFileNum int `json:"recycle_log_file_num"`
+
+	//RateLimiter 限制后台刷写和压缩的 IO 速度，nil 表示不限制。
+	//BackupEngine.Open 使用同一个 Options 时，备份和恢复也按 BytesPerSec 限速。
+	RateLimiter *RateLimiter `json:"rate_limiter,omitempty"`
+
+	//DisableLogFile 不在数据库目录中写 LOG 文件，rocksdb 的运行日志直接丢弃。
+	//使用 SetLogger 时日志转发到 Go，同样不会写 LOG 文件，不需要设置这个字段。
+	DisableLogFile bool `json:"disable_log_file"`
+
+	//StatsDumpPeriodSec 每隔多少秒把统计信息写入运行日志，0 表示使用 rocksdb 默认值（600 秒）
+	StatsDumpPeriodSec int `json:"stats_dump_period_sec"`
+
+	//StrictColumnFamilies 只对 OpenColumnFamilies 起作用，已有数据库中的 column family 和声明的不完全相同时打开失败，
+	//包括磁盘上有没有声明的 column family，以及声明的 column family 不存在（即使 CreateColumnFamiliesIfMissing 为 true），
+	//用于在启动时发现不同版本程序之间的 column family 不一致。新数据库按声明创建，不算不一致
+	StrictColumnFamilies bool `json:"strict_column_families"`
+
+	//hasLogger 是否通过 SetLogger 设置了 Go 日志
+	hasLogger bool
 }
 
 // GetDefaultOptions 返回默认的 RocksDB 选项
@@ -96,40 +179,119 @@ func GetDefaultOptions() *Options {
 	return opt
 }
 
-// Set 将 Go 的 Options 应用到 RocksDB 的 C 选项
-func (opt *Options) Set() {
+// Validate 检查选项的值是否有效，CompressionType 为空表示使用默认的 snappy
+func (opt *Options) Validate() error {
+	if opt.CompressionType != "" {
+		if _, ok := compressionTypes[opt.CompressionType]; !ok {
+			return &OptionError{Field: "CompressionType", Value: opt.CompressionType, Reason: "supported values are " + compressionNames()}
+		}
+	}
+	if opt.IncreaseParallelism < 0 {
+		return &OptionError{Field: "IncreaseParallelism", Value: fmt.Sprint(opt.IncreaseParallelism), Reason: "must not be negative"}
+	}
+	if opt.MaxOpenFiles < -1 {
+		return &OptionError{Field: "MaxOpenFiles", Value: fmt.Sprint(opt.MaxOpenFiles), Reason: "must be -1 (unlimited), 0 (default) or positive"}
+	}
+	if opt.WriteBufferSize < 0 {
+		return &OptionError{Field: "WriteBufferSize", Value: fmt.Sprint(opt.WriteBufferSize), Reason: "must not be negative"}
+	}
+	if opt.MaxBackgroundJobs < 0 {
+		return &OptionError{Field: "MaxBackgroundJobs", Value: fmt.Sprint(opt.MaxBackgroundJobs), Reason: "must not be negative"}
+	}
+	if opt.KeepLogFileNum < 0 {
+		return &OptionError{Field: "KeepLogFileNum", Value: fmt.Sprint(opt.KeepLogFileNum), Reason: "must not be negative"}
+	}
+	if opt.RecycleLogFileNum < 0 {
+		return &OptionError{Field: "RecycleLogFileNum", Value: fmt.Sprint(opt.RecycleLogFileNum), Reason: "must not be negative"}
+	}
+	if opt.StatsDumpPeriodSec < 0 {
+		return &OptionError{Field: "StatsDumpPeriodSec", Value: fmt.Sprint(opt.StatsDumpPeriodSec), Reason: "must not be negative"}
+	}
+	if opt.RateLimiter != nil {
+		if e := opt.RateLimiter.validate(); e != nil {
+			return e
+		}
+	}
+	if opt.ErrorIfExists && !opt.CreateIfMissing {
+		return &OptionConflictError{Fields: []string{"ErrorIfExists", "CreateIfMissing"}, Reason: "the database must not exist but is not allowed to be created"}
+	}
+	if opt.IncreaseParallelism > 0 && opt.MaxBackgroundJobs > 0 {
+		return &OptionConflictError{Fields: []string{"IncreaseParallelism", "MaxBackgroundJobs"}, Reason: "both set the number of background jobs, use only one"}
+	}
+	return nil
+}
+
+// Set 将 Go 的 Options 应用到 RocksDB 的 C 选项，选项无效时返回 *OptionError 或 *OptionConflictError，
+// 不会修改 C 选项。数值为 0、CompressionType 为空的字段表示使用 rocksdb 的默认值，默认值从新建的 C 选项读取后写入，
+// 所以再次 Set 时把字段改为 0 会恢复默认值，而不是保留之前写入的值。
+// IncreaseParallelism 修改的后台线程池、RateLimiter 和 DisableLogFile 写入后不能撤销，改为零值没有作用。
+// Open、AddColumnFamily 和 BackupEngine.Open 会自动调用这个函数。
+func (opt *Options) Set() error {
+	if e := opt.Validate(); e != nil {
+		return e
+	}
+	opt.Create()
+	defaults := C.rocksdb_options_create()
+	defer C.rocksdb_options_destroy(defaults)
 	C.rocksdb_options_set_create_missing_column_families(opt.handle, boolToUChar(opt.CreateColumnFamiliesIfMissing))
 	C.rocksdb_options_set_create_if_missing(opt.handle, boolToUChar(opt.CreateIfMissing))
-	C.rocksdb_options_increase_parallelism(opt.handle, C.int(opt.IncreaseParallelism))
 	C.rocksdb_options_set_error_if_exists(opt.handle, boolToUChar(opt.ErrorIfExists))
-	C.rocksdb_options_set_write_buffer_size(opt.handle, C.size_t(opt.WriteBufferSize))
-	C.rocksdb_options_set_max_open_files(opt.handle, C.int(opt.MaxOpenFiles))
-
-	switch opt.CompressionType {
-	case "none":
-		C.rocksdb_options_set_compression(opt.handle, C.rocksdb_no_compression)
-	case "snappy":
-		C.rocksdb_options_set_compression(opt.handle, C.rocksdb_snappy_compression)
-	case "zlib":
-		C.rocksdb_options_set_compression(opt.handle, C.rocksdb_zlib_compression)
-	case "bzip2":
-		C.rocksdb_options_set_compression(opt.handle, C.rocksdb_bz2_compression)
-	case "lz4":
-		C.rocksdb_options_set_compression(opt.handle, C.rocksdb_lz4_compression)
-	case "zstd":
-		C.rocksdb_options_set_compression(opt.handle, C.rocksdb_zstd_compression)
-	default:
-		C.rocksdb_options_set_compression(opt.handle, C.rocksdb_snappy_compression) // 默认 Snappy
-	}
-
-	C.rocksdb_options_set_target_file_size_base(opt.handle, C.uint64_t(opt.TargetFileSizeBase))
-	C.rocksdb_options_set_max_background_jobs(opt.handle, C.int(opt.MaxBackgroundJobs))
 	C.rocksdb_options_set_allow_concurrent_memtable_write(opt.handle, boolToUChar(opt.AllowConcurrentMemtableWrite))
-	C.rocksdb_options_set_keep_log_file_num(opt.handle, C.size_t(opt.KeepLogFileNum))
-	C.rocksdb_options_set_recycle_log_file_num(opt.handle, C.size_t(opt.RecycleLogFileNum))
+	if opt.IncreaseParallelism > 0 {
+		C.rocksdb_options_increase_parallelism(opt.handle, C.int(opt.IncreaseParallelism))
+	}
+	if opt.WriteBufferSize > 0 {
+		C.rocksdb_options_set_write_buffer_size(opt.handle, C.size_t(opt.WriteBufferSize))
+	} else {
+		C.rocksdb_options_set_write_buffer_size(opt.handle, C.rocksdb_options_get_write_buffer_size(defaults))
+	}
+	if opt.MaxOpenFiles != 0 {
+		C.rocksdb_options_set_max_open_files(opt.handle, C.int(opt.MaxOpenFiles))
+	} else {
+		C.rocksdb_options_set_max_open_files(opt.handle, C.rocksdb_options_get_max_open_files(defaults))
+	}
+	if compression, ok := compressionTypes[opt.CompressionType]; ok {
+		C.rocksdb_options_set_compression(opt.handle, compression)
+	} else {
+		C.rocksdb_options_set_compression(opt.handle, C.rocksdb_options_get_compression(defaults))
+	}
+	if opt.TargetFileSizeBase > 0 {
+		C.rocksdb_options_set_target_file_size_base(opt.handle, C.uint64_t(opt.TargetFileSizeBase))
+	} else {
+		C.rocksdb_options_set_target_file_size_base(opt.handle, C.rocksdb_options_get_target_file_size_base(defaults))
+	}
+	if opt.MaxBackgroundJobs > 0 {
+		C.rocksdb_options_set_max_background_jobs(opt.handle, C.int(opt.MaxBackgroundJobs))
+	} else if opt.IncreaseParallelism == 0 {
+		//IncreaseParallelism 已经设置了后台任务数量
+		C.rocksdb_options_set_max_background_jobs(opt.handle, C.rocksdb_options_get_max_background_jobs(defaults))
+	}
+	if opt.KeepLogFileNum > 0 {
+		C.rocksdb_options_set_keep_log_file_num(opt.handle, C.size_t(opt.KeepLogFileNum))
+	} else {
+		C.rocksdb_options_set_keep_log_file_num(opt.handle, C.rocksdb_options_get_keep_log_file_num(defaults))
+	}
+	if opt.RecycleLogFileNum > 0 {
+		C.rocksdb_options_set_recycle_log_file_num(opt.handle, C.size_t(opt.RecycleLogFileNum))
+	} else {
+		C.rocksdb_options_set_recycle_log_file_num(opt.handle, C.rocksdb_options_get_recycle_log_file_num(defaults))
+	}
+	if opt.RateLimiter != nil {
+		opt.RateLimiter.apply(opt.handle)
+	}
+	if opt.StatsDumpPeriodSec > 0 {
+		C.rocksdb_options_set_stats_dump_period_sec(opt.handle, C.uint(opt.StatsDumpPeriodSec))
+	} else {
+		C.rocksdb_options_set_stats_dump_period_sec(opt.handle, C.rocksdb_options_get_stats_dump_period_sec(defaults))
+	}
+	if opt.DisableLogFile && !opt.hasLogger {
+		opt.setDiscardLogger()
+	}
+	return nil
 }
 
-// Get Options 绑定了 C 内置资源，用完需要 free 释放
+// Get 从 C 选项读取全部字段，读取后的值就是 rocksdb 实际使用的值，0 值会被替换为 rocksdb 的默认值。
+// IncreaseParallelism、DisableWAL 和 RateLimiter 无法从 C 选项读取，保持不变。
 func (opt *Options) Get() {
 	opt.CreateColumnFamiliesIfMissing = ucharToBool(C.rocksdb_options_get_create_missing_column_families(opt.handle))
 	opt.CreateIfMissing = ucharToBool(C.rocksdb_options_get_create_if_missing(opt.handle))
@@ -141,9 +303,18 @@ func (opt *Options) Get() {
 	opt.AllowConcurrentMemtableWrite = ucharToBool(C.rocksdb_options_get_allow_concurrent_memtable_write(opt.handle))
 	opt.KeepLogFileNum = int(C.rocksdb_options_get_keep_log_file_num(opt.handle))
 	opt.RecycleLogFileNum = int(C.rocksdb_options_get_recycle_log_file_num(opt.handle))
+	opt.StatsDumpPeriodSec = int(C.rocksdb_options_get_stats_dump_period_sec(opt.handle))
+	opt.CompressionType = ""
+	compression := C.rocksdb_options_get_compression(opt.handle)
+	for name, value := range compressionTypes {
+		if value == compression {
+			opt.CompressionType = name
+			break
+		}
+	}
 }
 func (opt *Options) Create() {
-	if opt.handle != nil {
+	if opt.handle == nil {
 		opt.handle = C.rocksdb_options_create()
 	}
 }
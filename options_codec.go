@@ -0,0 +1,182 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// optionsJSON 和 Options 字段相同，但是没有 MarshalJSON 方法，避免递归
+type optionsJSON Options
+
+// rocksdbCompressionNames CompressionType 在 rocksdb 选项字符串中的名称
+var rocksdbCompressionNames = map[string]string{
+	"none":   "kNoCompression",
+	"snappy": "kSnappyCompression",
+	"zlib":   "kZlibCompression",
+	"bzip2":  "kBZip2Compression",
+	"lz4":    "kLZ4Compression",
+	"lz4hc":  "kLZ4HCCompression",
+	"zstd":   "kZSTD",
+}
+
+// MarshalJSON 输出 Go 字段，键名和 rocksdb 的选项名称一致
+func (opt *Options) MarshalJSON() ([]byte, error) {
+	return json.Marshal((*optionsJSON)(opt))
+}
+
+// UnmarshalJSON 解析 JSON 并应用到 C 选项，JSON 中没有的字段保持当前的值，未知字段和无效的值会返回错误，
+// 返回错误时 Options 不变。如果 Options 还没有绑定 C 资源会自动创建，用完需要 Close
+func (opt *Options) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if e := json.Unmarshal(data, &fields); e != nil {
+		return fmt.Errorf("rocksdb options: %w", e)
+	}
+	//encoding/json 匹配键名时不区分大小写，这里也按小写比较，否则 Create_If_Missing 会被误报为未知字段
+	known := optionsJSONFields()
+	var unknown []string
+	for name := range fields {
+		if !known[strings.ToLower(name)] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &OptionError{Field: strings.Join(unknown, ", "), Reason: "unknown option"}
+	}
+
+	parsed := optionsJSON(*opt)
+	//RateLimiter 是指针，解析到副本中，失败时不修改当前的值
+	if parsed.RateLimiter != nil {
+		rl := *parsed.RateLimiter
+		parsed.RateLimiter = &rl
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	//嵌套对象（rate_limiter）中的未知字段由 decoder 检查
+	decoder.DisallowUnknownFields()
+	if e := decoder.Decode(&parsed); e != nil {
+		return fmt.Errorf("rocksdb options: %w", e)
+	}
+	result := Options(parsed)
+	if e := result.Validate(); e != nil {
+		return e
+	}
+	*opt = result
+	return opt.Set()
+}
+
+// optionsJSONFields 返回 Options 的全部 JSON 键名，转为小写
+func optionsJSONFields() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(optionsJSON{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[strings.ToLower(name)] = true
+		}
+	}
+	return fields
+}
+
+// OptionsString 返回 rocksdb 格式的选项字符串，例如 "write_buffer_size=67108864;compression=kZSTD"，
+// 数值为 0 的字段表示使用 rocksdb 默认值，不会输出。IncreaseParallelism、DisableWAL、RateLimiter、
+// DisableLogFile 和 StrictColumnFamilies 不是 rocksdb 的持久化选项，也不会输出。
+func (opt *Options) OptionsString() (string, error) {
+	if e := opt.Validate(); e != nil {
+		return "", e
+	}
+	var items []string
+	add := func(name, value string) {
+		items = append(items, name+"="+value)
+	}
+	add("create_if_missing", strconv.FormatBool(opt.CreateIfMissing))
+	add("create_missing_column_families", strconv.FormatBool(opt.CreateColumnFamiliesIfMissing))
+	add("error_if_exists", strconv.FormatBool(opt.ErrorIfExists))
+	add("allow_concurrent_memtable_write", strconv.FormatBool(opt.AllowConcurrentMemtableWrite))
+	if opt.CompressionType != "" {
+		add("compression", rocksdbCompressionNames[opt.CompressionType])
+	}
+	if opt.WriteBufferSize != 0 {
+		add("write_buffer_size", strconv.Itoa(opt.WriteBufferSize))
+	}
+	if opt.MaxOpenFiles != 0 {
+		add("max_open_files", strconv.Itoa(opt.MaxOpenFiles))
+	}
+	if opt.TargetFileSizeBase != 0 {
+		add("target_file_size_base", strconv.FormatUint(opt.TargetFileSizeBase, 10))
+	}
+	if opt.MaxBackgroundJobs != 0 {
+		add("max_background_jobs", strconv.Itoa(opt.MaxBackgroundJobs))
+	}
+	if opt.KeepLogFileNum != 0 {
+		add("keep_log_file_num", strconv.Itoa(opt.KeepLogFileNum))
+	}
+	if opt.RecycleLogFileNum != 0 {
+		add("recycle_log_file_num", strconv.Itoa(opt.RecycleLogFileNum))
+	}
+	if opt.StatsDumpPeriodSec != 0 {
+		add("stats_dump_period_sec", strconv.Itoa(opt.StatsDumpPeriodSec))
+	}
+	return strings.Join(items, ";"), nil
+}
+
+// ParseOptionsString 解析 rocksdb 格式的选项字符串，返回的 Options 用完需要 Close。
+// 字符串可以包含 Options 没有对应字段的 rocksdb 选项，它们保存在 C 选项中，打开数据库时同样生效。
+// 选项名称未知或者值无效时返回错误。
+func ParseOptionsString(optsStr string) (*Options, error) {
+	return parseOptionsString(nil, optsStr)
+}
+
+// ParseOptionsString 在当前选项的基础上应用选项字符串，返回新的 Options，当前选项不变
+func (opt *Options) ParseOptionsString(optsStr string) (*Options, error) {
+	return parseOptionsString(opt, optsStr)
+}
+
+func parseOptionsString(base *Options, optsStr string) (*Options, error) {
+	var baseHandle *C.rocksdb_options_t
+	if base != nil && base.handle != nil {
+		baseHandle = base.handle
+	} else {
+		baseHandle = C.rocksdb_options_create()
+		defer C.rocksdb_options_destroy(baseHandle)
+	}
+	cStr := C.CString(optsStr)
+	defer C.free(unsafe.Pointer(cStr))
+	newHandle := C.rocksdb_options_create()
+
+	var err *C.char
+	//void rocksdb_get_options_from_string(const rocksdb_options_t* base_options, const char* opts_str,
+	//    rocksdb_options_t* new_options, char** errptr);
+	C.rocksdb_get_options_from_string(baseHandle, cStr, newHandle, &err)
+	if err != nil {
+		C.rocksdb_options_destroy(newHandle)
+		return nil, &OptionError{Field: "options string", Value: optsStr, Reason: charErr(err).Error()}
+	}
+	opt := &Options{handle: newHandle}
+	opt.Get()
+	if base != nil {
+		//这几个字段无法从 C 选项读取，沿用 base 的值
+		opt.IncreaseParallelism = base.IncreaseParallelism
+		opt.DisableWAL = base.DisableWAL
+		opt.RateLimiter = base.RateLimiter
+		opt.DisableLogFile = base.DisableLogFile
+		opt.StrictColumnFamilies = base.StrictColumnFamilies
+		opt.hasLogger = base.hasLogger
+	}
+	return opt, nil
+}
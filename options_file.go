@@ -0,0 +1,112 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"github.com/jsuserapp/ju"
+	"unsafe"
+)
+
+// latestOptionsCacheSize 加载 OPTIONS 文件时使用的共享 block cache 大小
+const latestOptionsCacheSize = 32 << 20
+
+// LatestOptions 数据库目录中最新的 OPTIONS 文件记录的选项
+type LatestOptions struct {
+	// DbOptions 数据库级别的选项
+	DbOptions *Options
+	// Names 全部 column family 的名称，顺序和 OPTIONS 文件一致
+	Names []string
+	// ColumnFamilies 每个 column family 持久化的选项
+	ColumnFamilies map[string]*Options
+}
+
+// Close 释放全部选项绑定的 C 资源
+func (lo *LatestOptions) Close() {
+	if lo.DbOptions != nil {
+		lo.DbOptions.Close()
+	}
+	for _, opt := range lo.ColumnFamilies {
+		opt.Close()
+	}
+}
+
+// LoadLatestOptions 读取数据库目录中最新的 OPTIONS 文件，返回的 LatestOptions 用完需要 Close。
+// 所有 column family 共享一个新建的 block cache。
+func LoadLatestOptions(path string) (*LatestOptions, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	env := C.rocksdb_create_default_env()
+	defer C.rocksdb_env_destroy(env)
+	cache := C.rocksdb_cache_create_lru(latestOptionsCacheSize)
+	defer C.rocksdb_cache_destroy(cache)
+
+	var dbOpts *C.rocksdb_options_t
+	var count C.size_t
+	var cNames **C.char
+	var cOpts **C.rocksdb_options_t
+	var err *C.char
+	//void rocksdb_load_latest_options(const char* db_path, rocksdb_env_t* env, bool ignore_unknown_options,
+	//    rocksdb_cache_t* cache, rocksdb_options_t** db_options, size_t* num_column_families,
+	//    char*** column_family_names, rocksdb_options_t*** column_family_options, char** errptr);
+	C.rocksdb_load_latest_options(cPath, env, false, cache, &dbOpts, &count, &cNames, &cOpts, &err)
+	if err != nil {
+		return nil, charErr(err)
+	}
+	//返回的全部对象由 rocksdb_load_latest_options_destroy 释放，这里保存独立的副本
+	defer C.rocksdb_load_latest_options_destroy(dbOpts, cNames, cOpts, count)
+
+	names := (*[1 << 30]*C.char)(unsafe.Pointer(cNames))[:count:count]
+	opts := (*[1 << 30]*C.rocksdb_options_t)(unsafe.Pointer(cOpts))[:count:count]
+	lo := &LatestOptions{
+		DbOptions:      &Options{handle: C.rocksdb_options_create_copy(dbOpts)},
+		Names:          make([]string, count),
+		ColumnFamilies: make(map[string]*Options, int(count)),
+	}
+	lo.DbOptions.Get()
+	for i := range names {
+		name := C.GoString(names[i])
+		opt := &Options{handle: C.rocksdb_options_create_copy(opts[i])}
+		opt.Get()
+		lo.Names[i] = name
+		lo.ColumnFamilies[name] = opt
+	}
+	return lo, nil
+}
+
+// OpenWithLatestOptions 按照数据库目录中最新的 OPTIONS 文件打开数据库，每个 column family 使用
+// 自己持久化的选项，例如创建时设置的压缩方式和 block 大小。数据库必须已经存在。
+// 打开后可以通过 ColumnFamily.Options 查看每个 column family 实际使用的选项。
+func OpenWithLatestOptions(path string) (*Db, error) {
+	if isPathOpened(path) {
+		return nil, errDbIsOpened
+	}
+	lo, e := LoadLatestOptions(path)
+	if e != nil {
+		return nil, e
+	}
+	defer lo.Close()
+
+	cfOpts := make([]*C.rocksdb_options_t, len(lo.Names))
+	for i, name := range lo.Names {
+		cfOpts[i] = lo.ColumnFamilies[name].handle
+	}
+	dbPath := C.CString(path)
+	defer C.free(unsafe.Pointer(dbPath))
+
+	rdb := &Db{cfList: ju.NewOrderMap[string, *ColumnFamily]()}
+	e = rdb.openCf(lo.DbOptions.handle, dbPath, lo.Names, cfOpts)
+	if e != nil {
+		return nil, wrapLockErr(e)
+	}
+	rdb.initDb(nil)
+	rdb.setOpened(path, lo.DbOptions.handle)
+	return rdb, nil
+}
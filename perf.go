@@ -0,0 +1,183 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"runtime"
+	"unsafe"
+)
+
+// PerfLevel PerfContext 的统计级别
+type PerfLevel int
+
+const (
+	PerfDisable                  PerfLevel = C.rocksdb_disable
+	PerfEnableCount              PerfLevel = C.rocksdb_enable_count
+	PerfEnableTimeExceptForMutex PerfLevel = C.rocksdb_enable_time_except_for_mutex
+	PerfEnableTime               PerfLevel = C.rocksdb_enable_time
+)
+
+// PerfMetric PerfContext 中的一项指标，时间类指标的单位是纳秒
+type PerfMetric int
+
+const (
+	PerfUserKeyComparisonCount    PerfMetric = C.rocksdb_user_key_comparison_count
+	PerfBlockCacheHitCount        PerfMetric = C.rocksdb_block_cache_hit_count
+	PerfBlockReadCount            PerfMetric = C.rocksdb_block_read_count
+	PerfBlockReadByte             PerfMetric = C.rocksdb_block_read_byte
+	PerfBlockReadTime             PerfMetric = C.rocksdb_block_read_time
+	PerfBlockChecksumTime         PerfMetric = C.rocksdb_block_checksum_time
+	PerfBlockDecompressTime       PerfMetric = C.rocksdb_block_decompress_time
+	PerfGetReadBytes              PerfMetric = C.rocksdb_get_read_bytes
+	PerfInternalKeySkippedCount   PerfMetric = C.rocksdb_internal_key_skipped_count
+	PerfInternalDeleteSkipped     PerfMetric = C.rocksdb_internal_delete_skipped_count
+	PerfGetSnapshotTime           PerfMetric = C.rocksdb_get_snapshot_time
+	PerfGetFromMemtableTime       PerfMetric = C.rocksdb_get_from_memtable_time
+	PerfGetFromMemtableCount      PerfMetric = C.rocksdb_get_from_memtable_count
+	PerfGetPostProcessTime        PerfMetric = C.rocksdb_get_post_process_time
+	PerfGetFromOutputFilesTime    PerfMetric = C.rocksdb_get_from_output_files_time
+	PerfSeekOnMemtableTime        PerfMetric = C.rocksdb_seek_on_memtable_time
+	PerfSeekInternalSeekTime      PerfMetric = C.rocksdb_seek_internal_seek_time
+	PerfFindNextUserEntryTime     PerfMetric = C.rocksdb_find_next_user_entry_time
+	PerfWriteWalTime              PerfMetric = C.rocksdb_write_wal_time
+	PerfWriteMemtableTime         PerfMetric = C.rocksdb_write_memtable_time
+	PerfWriteDelayTime            PerfMetric = C.rocksdb_write_delay_time
+	PerfDbMutexLockNanos          PerfMetric = C.rocksdb_db_mutex_lock_nanos
+	PerfDbConditionWaitNanos      PerfMetric = C.rocksdb_db_condition_wait_nanos
+	PerfReadIndexBlockNanos       PerfMetric = C.rocksdb_read_index_block_nanos
+	PerfReadFilterBlockNanos      PerfMetric = C.rocksdb_read_filter_block_nanos
+	PerfNewTableBlockIterNanos    PerfMetric = C.rocksdb_new_table_block_iter_nanos
+	PerfBlockSeekNanos            PerfMetric = C.rocksdb_block_seek_nanos
+	PerfFindTableNanos            PerfMetric = C.rocksdb_find_table_nanos
+	PerfBloomMemtableHitCount     PerfMetric = C.rocksdb_bloom_memtable_hit_count
+	PerfBloomMemtableMissCount    PerfMetric = C.rocksdb_bloom_memtable_miss_count
+	PerfBloomSstHitCount          PerfMetric = C.rocksdb_bloom_sst_hit_count
+	PerfBloomSstMissCount         PerfMetric = C.rocksdb_bloom_sst_miss_count
+	PerfBlockReadCpuTime          PerfMetric = C.rocksdb_block_read_cpu_time
+	PerfInternalRangeDelReseekCnt PerfMetric = C.rocksdb_internal_range_del_reseek_count
+)
+
+// SetPerfLevel 设置当前线程的 PerfContext 统计级别。
+// rocksdb 的 PerfContext 是线程局部的，goroutine 可能在不同的系统线程间切换，
+// 所以调用前需要 runtime.LockOSThread，并且在同一个线程上读取 PerfContext。
+func SetPerfLevel(level PerfLevel) {
+	C.rocksdb_set_perf_level(C.int(level))
+}
+
+// PerfContext 当前线程的性能计数器，只能在创建它的系统线程上使用，用完需要 Close
+type PerfContext struct {
+	handle *C.rocksdb_perfcontext_t
+}
+
+// NewPerfContext 返回当前线程的 PerfContext，调用前需要 runtime.LockOSThread
+func NewPerfContext() *PerfContext {
+	return &PerfContext{handle: C.rocksdb_perfcontext_create()}
+}
+
+// Reset 清零全部计数
+func (pc *PerfContext) Reset() {
+	C.rocksdb_perfcontext_reset(pc.handle)
+}
+
+// Report 返回可读的计数报告，excludeZero 为 true 时不包含值为 0 的项
+func (pc *PerfContext) Report(excludeZero bool) string {
+	cStr := C.rocksdb_perfcontext_report(pc.handle, boolToUChar(excludeZero))
+	defer C.rocksdb_free(unsafe.Pointer(cStr))
+	return C.GoString(cStr)
+}
+
+// Metric 读取单项指标
+func (pc *PerfContext) Metric(metric PerfMetric) uint64 {
+	return uint64(C.rocksdb_perfcontext_metric(pc.handle, C.int(metric)))
+}
+
+// Breakdown 读取常用指标的快照
+func (pc *PerfContext) Breakdown() PerfBreakdown {
+	return PerfBreakdown{
+		UserKeyComparisonCount: pc.Metric(PerfUserKeyComparisonCount),
+		BlockCacheHitCount:     pc.Metric(PerfBlockCacheHitCount),
+		BlockReadCount:         pc.Metric(PerfBlockReadCount),
+		BlockReadByte:          pc.Metric(PerfBlockReadByte),
+		BlockReadTime:          pc.Metric(PerfBlockReadTime),
+		BlockDecompressTime:    pc.Metric(PerfBlockDecompressTime),
+		GetSnapshotTime:        pc.Metric(PerfGetSnapshotTime),
+		GetFromMemtableTime:    pc.Metric(PerfGetFromMemtableTime),
+		GetFromMemtableCount:   pc.Metric(PerfGetFromMemtableCount),
+		GetFromOutputFilesTime: pc.Metric(PerfGetFromOutputFilesTime),
+		GetPostProcessTime:     pc.Metric(PerfGetPostProcessTime),
+		BloomMemtableHitCount:  pc.Metric(PerfBloomMemtableHitCount),
+		BloomMemtableMissCount: pc.Metric(PerfBloomMemtableMissCount),
+		BloomSstHitCount:       pc.Metric(PerfBloomSstHitCount),
+		BloomSstMissCount:      pc.Metric(PerfBloomSstMissCount),
+		FindTableNanos:         pc.Metric(PerfFindTableNanos),
+		DbMutexLockNanos:       pc.Metric(PerfDbMutexLockNanos),
+	}
+}
+
+// Close 释放 PerfContext 的包装对象，线程局部的计数本身不受影响
+func (pc *PerfContext) Close() {
+	if pc.handle != nil {
+		C.rocksdb_perfcontext_destroy(pc.handle)
+		pc.handle = nil
+	}
+}
+
+// PerfBreakdown 一次读操作的性能分解，时间单位是纳秒
+type PerfBreakdown struct {
+	UserKeyComparisonCount uint64
+	BlockCacheHitCount     uint64
+	BlockReadCount         uint64
+	BlockReadByte          uint64
+	BlockReadTime          uint64
+	BlockDecompressTime    uint64
+	GetSnapshotTime        uint64
+	GetFromMemtableTime    uint64
+	GetFromMemtableCount   uint64
+	GetFromOutputFilesTime uint64
+	GetPostProcessTime     uint64
+	BloomMemtableHitCount  uint64
+	BloomMemtableMissCount uint64
+	BloomSstHitCount       uint64
+	BloomSstMissCount      uint64
+	FindTableNanos         uint64
+	// DbMutexLockNanos 等待 DB mutex 的时间，只在 PerfEnableTime 级别统计，其他级别为 0
+	DbMutexLockNanos uint64
+	// Report 完整的计数报告，不包含值为 0 的项
+	Report string
+}
+
+// GetTraced 和 Get 相同，同时返回这次读取的性能分解。统计级别为 PerfEnableTime，包括 DbMutexLockNanos。
+// rocksdb 的 C 接口不能读取当前的统计级别，无法在测量后恢复，所以测量在一个新的系统线程上进行，
+// 结束后这个线程随之销毁，调用者线程的统计级别不受影响。每次调用都会创建线程，只适合排查个别查询。
+func (cf *ColumnFamily) GetTraced(key []byte) ([]byte, *PerfBreakdown, error) {
+	type traced struct {
+		value     []byte
+		breakdown PerfBreakdown
+		err       error
+	}
+	done := make(chan traced, 1)
+	go func() {
+		//不调用 UnlockOSThread，goroutine 结束时 Go 会销毁这个线程，线程局部的统计级别也随之丢弃
+		runtime.LockOSThread()
+		SetPerfLevel(PerfEnableTime)
+		pc := NewPerfContext()
+		defer pc.Close()
+		pc.Reset()
+
+		var t traced
+		t.value, t.err = cf.Get(key)
+		t.breakdown = pc.Breakdown()
+		t.breakdown.Report = pc.Report(true)
+		done <- t
+	}()
+	t := <-done
+	return t.value, &t.breakdown, t.err
+}
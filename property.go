@@ -0,0 +1,124 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"strconv"
+	"unsafe"
+)
+
+// PropertyName rocksdb 数据库属性名称，完整列表参考 rocksdb/db.h 中的 DB::Properties
+type PropertyName string
+
+// 字符串类型的属性，使用 Property 读取
+const (
+	// PropStats 数据库的统计信息，包含 column family 和 DB 级别的多行文本
+	PropStats PropertyName = "rocksdb.stats"
+	// PropCfStats column family 的统计信息
+	PropCfStats PropertyName = "rocksdb.cfstats"
+	// PropDbStats DB 级别的统计信息
+	PropDbStats PropertyName = "rocksdb.dbstats"
+	// PropLevelStats 每一层的文件数和大小
+	PropLevelStats PropertyName = "rocksdb.levelstats"
+	// PropSsTables 所有 SST 文件的摘要
+	PropSsTables PropertyName = "rocksdb.sstables"
+	// PropOptionsStatistics 需要开启 Statistics 才有内容
+	PropOptionsStatistics PropertyName = "rocksdb.options-statistics"
+)
+
+// 整数类型的属性，使用 IntProperty 读取，也可以用 Property 读取字符串形式
+const (
+	PropNumImmutableMemTable           PropertyName = "rocksdb.num-immutable-mem-table"
+	PropMemTableFlushPending           PropertyName = "rocksdb.mem-table-flush-pending"
+	PropCompactionPending              PropertyName = "rocksdb.compaction-pending"
+	PropBackgroundErrors               PropertyName = "rocksdb.background-errors"
+	PropCurSizeActiveMemTable          PropertyName = "rocksdb.cur-size-active-mem-table"
+	PropCurSizeAllMemTables            PropertyName = "rocksdb.cur-size-all-mem-tables"
+	PropSizeAllMemTables               PropertyName = "rocksdb.size-all-mem-tables"
+	PropNumEntriesActiveMemTable       PropertyName = "rocksdb.num-entries-active-mem-table"
+	PropNumDeletesActiveMemTable       PropertyName = "rocksdb.num-deletes-active-mem-table"
+	PropNumEntriesImmMemTables         PropertyName = "rocksdb.num-entries-imm-mem-tables"
+	PropEstimateNumKeys                PropertyName = "rocksdb.estimate-num-keys"
+	PropEstimateTableReadersMem        PropertyName = "rocksdb.estimate-table-readers-mem"
+	PropNumSnapshots                   PropertyName = "rocksdb.num-snapshots"
+	PropNumLiveVersions                PropertyName = "rocksdb.num-live-versions"
+	PropEstimateLiveDataSize           PropertyName = "rocksdb.estimate-live-data-size"
+	PropTotalSstFilesSize              PropertyName = "rocksdb.total-sst-files-size"
+	PropLiveSstFilesSize               PropertyName = "rocksdb.live-sst-files-size"
+	PropEstimatePendingCompactionBytes PropertyName = "rocksdb.estimate-pending-compaction-bytes"
+	PropNumRunningCompactions          PropertyName = "rocksdb.num-running-compactions"
+	PropNumRunningFlushes              PropertyName = "rocksdb.num-running-flushes"
+	PropActualDelayedWriteRate         PropertyName = "rocksdb.actual-delayed-write-rate"
+	PropIsWriteStopped                 PropertyName = "rocksdb.is-write-stopped"
+	PropBlockCacheCapacity             PropertyName = "rocksdb.block-cache-capacity"
+	PropBlockCacheUsage                PropertyName = "rocksdb.block-cache-usage"
+	PropBlockCachePinnedUsage          PropertyName = "rocksdb.block-cache-pinned-usage"
+)
+
+// PropNumFilesAtLevel 返回指定层级文件数的属性名，例如 rocksdb.num-files-at-level0
+func PropNumFilesAtLevel(level int) PropertyName {
+	return PropertyName("rocksdb.num-files-at-level" + strconv.Itoa(level))
+}
+
+// Property 读取数据库属性，作用于 default column family，属性不存在时返回 false
+func (rdb *Db) Property(name PropertyName) (string, bool) {
+	return rdb.GetDefault().Property(name)
+}
+
+// IntProperty 读取整数类型的数据库属性，作用于 default column family，属性不存在或者不是整数时返回 false
+func (rdb *Db) IntProperty(name PropertyName) (uint64, bool) {
+	return rdb.GetDefault().IntProperty(name)
+}
+
+// Property 读取 column family 的属性，属性不存在时返回 false
+func (cf *ColumnFamily) Property(name PropertyName) (string, bool) {
+	if cf.use() != nil {
+		return "", false
+	}
+	defer cf.done()
+	cName := C.CString(string(name))
+	defer C.free(unsafe.Pointer(cName))
+	//char* rocksdb_property_value_cf(rocksdb_t* db, rocksdb_column_family_handle_t* column_family, const char* propname);
+	value := C.rocksdb_property_value_cf(cf.rocks.db, cf.handle, cName)
+	if value == nil {
+		return "", false
+	}
+	defer C.rocksdb_free(unsafe.Pointer(value))
+	return C.GoString(value), true
+}
+
+// IntProperty 读取 column family 的整数属性，属性不存在或者不是整数时返回 false
+func (cf *ColumnFamily) IntProperty(name PropertyName) (uint64, bool) {
+	if cf.use() != nil {
+		return 0, false
+	}
+	defer cf.done()
+	cName := C.CString(string(name))
+	defer C.free(unsafe.Pointer(cName))
+	var value C.uint64_t
+	//int rocksdb_property_int_cf(rocksdb_t* db, rocksdb_column_family_handle_t* column_family, const char* propname, uint64_t* out_val);
+	if C.rocksdb_property_int_cf(cf.rocks.db, cf.handle, cName, &value) != 0 {
+		return 0, false
+	}
+	return uint64(value), true
+}
+
+// EstimateNumKeys 估算 column family 中的键数量，结果可能因为重复键和删除标记而不准确
+func (cf *ColumnFamily) EstimateNumKeys() uint64 {
+	num, _ := cf.IntProperty(PropEstimateNumKeys)
+	return num
+}
+
+// EstimateLiveDataSize 估算 column family 中有效数据的大小（字节）
+func (cf *ColumnFamily) EstimateLiveDataSize() uint64 {
+	size, _ := cf.IntProperty(PropEstimateLiveDataSize)
+	return size
+}
@@ -0,0 +1,185 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"unsafe"
+)
+
+// Range 键范围 [Start, Limit)，Start 包含在内，Limit 不包含
+type Range struct {
+	Start []byte
+	Limit []byte
+}
+
+var errInvalidSplit = errors.New("split count must be greater than 0")
+
+// splitSearchSteps SplitRange 查找每个分割点时的二分次数
+const splitSearchSteps = 48
+
+// ApproximateSizes 估算每个范围占用的磁盘空间（字节），不需要扫描数据。
+// includeMemtable 为 true 时同时估算 MemTable 中还没有刷写的数据。
+func (cf *ColumnFamily) ApproximateSizes(ranges []Range, includeMemtable bool) ([]uint64, error) {
+	flags := C.uint8_t(C.rocksdb_size_approximation_flags_include_files)
+	if includeMemtable {
+		flags |= C.rocksdb_size_approximation_flags_include_memtable
+	}
+	return cf.approximateSizes(ranges, flags)
+}
+
+// ApproximateMemtableSize 估算范围内还在 MemTable 中的数据大小（字节）。
+// rocksdb 的 C 接口没有提供 GetApproximateMemTableStats 中的条目数，所以只有大小。
+func (cf *ColumnFamily) ApproximateMemtableSize(r Range) (uint64, error) {
+	sizes, e := cf.approximateSizes([]Range{r}, C.rocksdb_size_approximation_flags_include_memtable)
+	if e != nil {
+		return 0, e
+	}
+	return sizes[0], nil
+}
+
+func (cf *ColumnFamily) approximateSizes(ranges []Range, flags C.uint8_t) ([]uint64, error) {
+	count := len(ranges)
+	if count == 0 {
+		return nil, nil
+	}
+	//键要复制到 C 内存，C 函数的参数数组中不能保存 Go 指针
+	starts := make([]*C.char, count)
+	startLens := make([]C.size_t, count)
+	limits := make([]*C.char, count)
+	limitLens := make([]C.size_t, count)
+	defer func() {
+		for i := 0; i < count; i++ {
+			C.free(unsafe.Pointer(starts[i]))
+			C.free(unsafe.Pointer(limits[i]))
+		}
+	}()
+	for i, r := range ranges {
+		starts[i] = (*C.char)(C.CBytes(r.Start))
+		startLens[i] = C.size_t(len(r.Start))
+		limits[i] = (*C.char)(C.CBytes(r.Limit))
+		limitLens[i] = C.size_t(len(r.Limit))
+	}
+	sizes := make([]C.uint64_t, count)
+	if e := cf.use(); e != nil {
+		return nil, e
+	}
+	defer cf.done()
+
+	var err *C.char
+	//void rocksdb_approximate_sizes_cf_with_flags(rocksdb_t* db, rocksdb_column_family_handle_t* column_family,
+	//    int num_ranges, const char* const* range_start_key, const size_t* range_start_key_len,
+	//    const char* const* range_limit_key, const size_t* range_limit_key_len,
+	//    uint8_t include_flags, uint64_t* sizes, char** errptr);
+	C.rocksdb_approximate_sizes_cf_with_flags(cf.rocks.db, cf.handle, C.int(count),
+		&starts[0], &startLens[0], &limits[0], &limitLens[0], flags, &sizes[0], &err)
+	if err != nil {
+		return nil, charErr(err)
+	}
+	result := make([]uint64, count)
+	for i, size := range sizes {
+		result[i] = uint64(size)
+	}
+	return result, nil
+}
+
+// SplitRange 根据估算的大小把 [start, end) 分成 n 个大小接近的子范围，子范围首尾相接。
+// start 为空表示从第一个键开始，end 为空表示到最后一个键为止，这时最后一个子范围的 Limit 也为空，
+// 包含分割之后写入的更大的键。
+// 估算的精度受 SST 文件的索引粒度影响，数据量很小的时候分割结果可能很不均匀。
+func (cf *ColumnFamily) SplitRange(start, end []byte, n int) ([]Range, error) {
+	if n <= 0 {
+		return nil, errInvalidSplit
+	}
+	//limit 是最后一个子范围的 Limit，end 为空时按最后一个键估算，但是 limit 保持为空
+	limit := end
+	if len(end) == 0 {
+		end = cf.upperBound()
+	}
+	if n == 1 || bytes.Compare(start, end) >= 0 {
+		return []Range{{Start: start, Limit: limit}}, nil
+	}
+	sizes, e := cf.ApproximateSizes([]Range{{Start: start, Limit: end}}, true)
+	if e != nil {
+		return nil, e
+	}
+	total := sizes[0]
+
+	//把键当作定长的大端整数，在整数空间里二分查找每个分割点
+	width := max(len(start), len(end)) + 1
+	lo := keyToInt(start, width)
+	hi := keyToInt(end, width)
+
+	result := make([]Range, 0, n)
+	prev := start
+	for i := 1; i < n; i++ {
+		target := total * uint64(i) / uint64(n)
+		left, right := new(big.Int).Set(lo), new(big.Int).Set(hi)
+		for step := 0; step < splitSearchSteps; step++ {
+			mid := new(big.Int).Add(left, right)
+			mid.Rsh(mid, 1)
+			if mid.Cmp(left) == 0 {
+				break
+			}
+			sizes, e = cf.ApproximateSizes([]Range{{Start: start, Limit: intToKey(mid, width)}}, true)
+			if e != nil {
+				return nil, e
+			}
+			if sizes[0] < target {
+				left = mid
+			} else {
+				right = mid
+			}
+		}
+		key := intToKey(right, width)
+		if bytes.Compare(key, prev) <= 0 {
+			continue
+		}
+		result = append(result, Range{Start: prev, Limit: key})
+		prev = key
+		lo = right
+	}
+	result = append(result, Range{Start: prev, Limit: limit})
+	return result, nil
+}
+
+// upperBound 返回比 column family 中最后一个键大的最小键，column family 为空时返回 nil
+func (cf *ColumnFamily) upperBound() []byte {
+	if cf.use() != nil {
+		return nil
+	}
+	defer cf.done()
+	iter := C.rocksdb_create_iterator_cf(cf.rocks.db, cf.rocks.ro, cf.handle)
+	defer C.rocksdb_iter_destroy(iter)
+	C.rocksdb_iter_seek_to_last(iter)
+	if C.rocksdb_iter_valid(iter) == 0 {
+		return nil
+	}
+	keyLen := C.size_t(0)
+	keyPtr := C.rocksdb_iter_key(iter, &keyLen)
+	key := C.GoBytes(unsafe.Pointer(keyPtr), C.int(keyLen))
+	return append(key, 0)
+}
+
+func keyToInt(key []byte, width int) *big.Int {
+	buf := make([]byte, width)
+	copy(buf, key)
+	return new(big.Int).SetBytes(buf)
+}
+
+func intToKey(v *big.Int, width int) []byte {
+	buf := make([]byte, width)
+	v.FillBytes(buf)
+	//去掉末尾补齐的 0，让分割点尽量短
+	return bytes.TrimRight(buf, "\x00")
+}
@@ -0,0 +1,68 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimiter 限制后台刷写和压缩的磁盘 IO 速度，避免影响前台读写。
+// 打开后不能修改速度：rocksdb 的 C 接口没有提供 RateLimiter::SetBytesPerSecond，rate_limiter 也不是
+// SetDBOptions 可以修改的选项，需要修改时用新的 RateLimiter 重新打开数据库，或者使用 AutoTuned
+type RateLimiter struct {
+	// BytesPerSec 每秒允许写入的字节数，必须大于 0
+	BytesPerSec int64 `json:"bytes_per_sec"`
+	// RefillPeriod 令牌补充周期，0 表示使用 rocksdb 默认值 100ms
+	RefillPeriod time.Duration `json:"refill_period"`
+	// Fairness 高优先级请求和低优先级请求的公平度，0 表示使用 rocksdb 默认值 10
+	Fairness int32 `json:"fairness"`
+	// AutoTuned 为 true 时，rocksdb 根据实际负载在 BytesPerSec 以下自动调整速度，BytesPerSec 作为上限
+	AutoTuned bool `json:"auto_tuned"`
+}
+
+const (
+	defaultRefillPeriod = 100 * time.Millisecond
+	defaultFairness     = 10
+)
+
+func (rl *RateLimiter) validate() error {
+	if rl.BytesPerSec <= 0 {
+		return &OptionError{Field: "RateLimiter.BytesPerSec", Value: fmt.Sprint(rl.BytesPerSec), Reason: "must be greater than 0"}
+	}
+	if rl.RefillPeriod < 0 {
+		return &OptionError{Field: "RateLimiter.RefillPeriod", Value: rl.RefillPeriod.String(), Reason: "must not be negative"}
+	}
+	if rl.Fairness < 0 {
+		return &OptionError{Field: "RateLimiter.Fairness", Value: fmt.Sprint(rl.Fairness), Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// apply 创建 rocksdb 的 rate limiter 并设置到选项，选项持有它的引用，这里可以直接释放包装对象
+func (rl *RateLimiter) apply(opts *C.rocksdb_options_t) {
+	refill := rl.RefillPeriod
+	if refill == 0 {
+		refill = defaultRefillPeriod
+	}
+	fairness := rl.Fairness
+	if fairness == 0 {
+		fairness = defaultFairness
+	}
+	var limiter *C.rocksdb_ratelimiter_t
+	if rl.AutoTuned {
+		limiter = C.rocksdb_ratelimiter_create_auto_tuned(C.int64_t(rl.BytesPerSec), C.int64_t(refill.Microseconds()), C.int32_t(fairness))
+	} else {
+		limiter = C.rocksdb_ratelimiter_create(C.int64_t(rl.BytesPerSec), C.int64_t(refill.Microseconds()), C.int32_t(fairness))
+	}
+	C.rocksdb_options_set_ratelimiter(opts, limiter)
+	C.rocksdb_ratelimiter_destroy(limiter)
+}
@@ -0,0 +1,192 @@
+package rocksdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLocked 数据库的 LOCK 文件被其他进程持有
+var ErrLocked = errors.New("database is locked by another process")
+
+// ErrSharedInUse 共享的数据库还有其他句柄在使用
+var ErrSharedInUse = errors.New("shared database is still used by other handles")
+
+var errSharedDb = errors.New("database is opened by OpenShared, close it through its SharedDb handle")
+
+// 等待 LOCK 时重试的间隔，每次翻倍，最大为 lockRetryMax
+const (
+	lockRetryMin = 50 * time.Millisecond
+	lockRetryMax = 2 * time.Second
+)
+
+// sharedDb 一个共享打开的数据库，db 为 nil 时表示正在打开或者正在关闭，等待 ready 关闭后重新查看
+type sharedDb struct {
+	db    *Db
+	refs  int
+	ready chan struct{}
+}
+
+// sharedDbs OpenShared 打开的数据库，键是数据库的绝对路径
+var sharedDbs = struct {
+	sync.Mutex
+	dbs map[string]*sharedDb
+}{dbs: map[string]*sharedDb{}}
+
+// isLockError rocksdb 打开时获取 LOCK 失败的错误，例如 "IO error: While lock file: ./db/LOCK: Resource temporarily unavailable"。
+// 当前进程已经持有锁时的 "lock hold by current process" 重试也不会成功，不算在内
+func isLockError(e error) bool {
+	msg := strings.ToLower(e.Error())
+	return strings.Contains(msg, "lock file") && !strings.Contains(msg, "current process")
+}
+
+func wrapLockErr(e error) error {
+	if isLockError(e) {
+		return fmt.Errorf("%w: %v", ErrLocked, e)
+	}
+	return e
+}
+
+// OpenContext 和 Open 相同，但是数据库被其他进程锁定时按退避间隔重试，直到打开成功或者 ctx 结束。
+// ctx 结束时返回的错误同时匹配 ErrLocked 和 ctx.Err()，其他错误立即返回
+func OpenContext(ctx context.Context, path string, opts *Options, descs ...ColumnFamilyDescriptor) (*Db, error) {
+	delay := lockRetryMin
+	for {
+		db, e := Open(path, opts, descs...)
+		if e == nil || !errors.Is(e, ErrLocked) {
+			return db, e
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w, gave up: %w", e, ctx.Err())
+		case <-timer.C:
+		}
+		delay = min(delay*2, lockRetryMax)
+	}
+}
+
+// SharedDb OpenShared 返回的句柄，同一个路径的句柄共享同一个 *Db，每个句柄持有一个引用。
+// 句柄的 Close 只释放自己的引用，重复调用没有作用，最后一个引用释放时才真正关闭数据库
+type SharedDb struct {
+	*Db
+	mut    sync.Mutex
+	closed bool
+}
+
+// OpenShared 在进程内共享打开的数据库，同一个路径再次调用时返回新的句柄，句柄共享同一个 *Db。
+// 只有第一次打开时使用 opts 和 descs，之后的调用忽略它们，需要新的 column family 时使用 AddColumnFamily。
+// 打开时按 OpenContext 等待其他进程释放 LOCK。路径已经被 Open 等非共享方式打开时返回错误
+func OpenShared(ctx context.Context, path string, opts *Options, descs ...ColumnFamilyDescriptor) (*SharedDb, error) {
+	key := cleanDbPath(path)
+	for {
+		sharedDbs.Lock()
+		entry := sharedDbs.dbs[key]
+		if entry == nil {
+			entry = &sharedDb{ready: make(chan struct{})}
+			sharedDbs.dbs[key] = entry
+			sharedDbs.Unlock()
+
+			db, e := OpenContext(ctx, path, opts, descs...)
+			sharedDbs.Lock()
+			defer sharedDbs.Unlock()
+			close(entry.ready)
+			if e != nil {
+				delete(sharedDbs.dbs, key)
+				return nil, e
+			}
+			db.sharedKey = key
+			entry.db, entry.refs = db, 1
+			return &SharedDb{Db: db}, nil
+		}
+		if entry.db != nil {
+			entry.refs++
+			sharedDbs.Unlock()
+			return &SharedDb{Db: entry.db}, nil
+		}
+		//其他调用正在打开或者关闭这个数据库
+		ready := entry.ready
+		sharedDbs.Unlock()
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close 释放这个句柄的引用，最后一个引用释放时关闭数据库。重复调用没有作用
+func (h *SharedDb) Close() {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	entry, last := h.release(false)
+	if !last {
+		return
+	}
+	h.Db.close()
+	h.unregister(entry)
+}
+
+// Shutdown 和 Db.Shutdown 相同，但是只有持有最后一个引用的句柄才能调用，还有其他句柄时返回 ErrSharedInUse，
+// 数据库不受影响。Shutdown 返回错误并且数据库没有关闭时，这个句柄仍然有效
+func (h *SharedDb) Shutdown(ctx context.Context, opts ShutdownOptions) error {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if h.closed {
+		return ErrClosed
+	}
+	entry, last := h.release(true)
+	if entry == nil {
+		return ErrClosed
+	}
+	if !last {
+		return ErrSharedInUse
+	}
+	closed, e := h.Db.shutdown(ctx, opts)
+	if !closed {
+		//数据库保持打开，恢复登记，等待的 OpenShared 可以继续使用它
+		sharedDbs.Lock()
+		entry.db, entry.refs = h.Db, 1
+		close(entry.ready)
+		sharedDbs.Unlock()
+		return e
+	}
+	h.closed = true
+	h.unregister(entry)
+	return e
+}
+
+// release 释放句柄的引用，last 为 true 表示这是最后一个引用，这时数据库保持登记但是标记为正在关闭，
+// 这期间的 OpenShared 会等待，关闭后调用 unregister。onlyLast 为 true 时不是最后一个引用就不释放
+func (h *SharedDb) release(onlyLast bool) (entry *sharedDb, last bool) {
+	sharedDbs.Lock()
+	defer sharedDbs.Unlock()
+	entry = sharedDbs.dbs[h.sharedKey]
+	if entry == nil || entry.db != h.Db {
+		return nil, false
+	}
+	if entry.refs > 1 {
+		if !onlyLast {
+			entry.refs--
+		}
+		return entry, false
+	}
+	entry.db, entry.refs = nil, 0
+	entry.ready = make(chan struct{})
+	return entry, true
+}
+
+func (h *SharedDb) unregister(entry *sharedDb) {
+	sharedDbs.Lock()
+	delete(sharedDbs.dbs, h.sharedKey)
+	close(entry.ready)
+	sharedDbs.Unlock()
+}
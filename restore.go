@@ -0,0 +1,315 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// RestoreRequest 恢复备份的参数
+type RestoreRequest struct {
+	// DbDir 恢复的数据库目录
+	DbDir string
+	// WalDir WAL 文件的目录，为空时和 DbDir 相同，和打开数据库时 Options 的 wal_dir 对应
+	WalDir string
+	// BackupID 恢复的备份点，Latest 为 true 时忽略
+	BackupID uint32
+	// Latest 恢复最新的备份点
+	Latest bool
+	// KeepLogFiles 不覆盖 WalDir 中已有的 WAL 文件，通常和 BackupOptions.BackupLogFiles 为 false 一起使用，
+	// 用备份的 SST 加上现有的 WAL 恢复到最新的状态
+	KeepLogFiles bool
+	// ViaTempDir 先恢复到 DbDir 同级的临时目录，成功后再用 rename 替换 DbDir，恢复失败时原来的目录不受影响。
+	// 原来的目录会被删除。WalDir 不在 DbDir 中时和 DbDir 一起替换，其中一个失败时两个都恢复原状。
+	// 替换由多次改名组成，不是原子操作，进程在中途退出时用 RecoverRestore(DbDir) 恢复原来的目录。
+	ViaTempDir bool
+}
+
+var errRestoreKeepLogsTemp = errors.New("KeepLogFiles can not be used with ViaTempDir, the temp dir has no log files to keep")
+
+func (req *RestoreRequest) walDir() string {
+	if req.WalDir == "" {
+		return req.DbDir
+	}
+	return req.WalDir
+}
+
+func (req *RestoreRequest) validate() error {
+	if req.DbDir == "" {
+		return errors.New("restore DbDir is empty")
+	}
+	if isPathOpened(req.DbDir) || isPathOpened(req.walDir()) {
+		return errDbIsOpened
+	}
+	if req.KeepLogFiles && req.ViaTempDir {
+		return errRestoreKeepLogsTemp
+	}
+	return nil
+}
+
+// Restore 按 req 恢复备份点，目标目录被当前进程的 Db 打开时返回错误。OpenBackupEngineReadOnly 打开的备份引擎不能恢复
+func (be *BackupEngine) Restore(req RestoreRequest) error {
+	if be.readOnly {
+		//只读模式下没有 rocksdb 的备份引擎
+		return ErrNotSupported
+	}
+	if e := be.use(); e != nil {
+		return e
+	}
+	defer be.done()
+	if e := req.validate(); e != nil {
+		return e
+	}
+	if req.ViaTempDir {
+		return restoreViaTempDir(be.engine, req)
+	}
+	return restoreBackup(be.engine, req.DbDir, req.walDir(), req)
+}
+
+// RestoreBackup 打开 backupPath 中的备份，把 backupId 恢复到 dbPath，backupId 小于 0 时恢复最新的备份点
+func RestoreBackup(dbPath, backupPath string, backupId int) error {
+	req := RestoreRequest{DbDir: dbPath, Latest: backupId < 0}
+	if backupId >= 0 {
+		req.BackupID = uint32(backupId)
+	}
+	if e := req.validate(); e != nil {
+		return e
+	}
+	engine, e := openBackupEngine(backupPath, nil)
+	if e != nil {
+		return e
+	}
+	defer C.rocksdb_backup_engine_close(engine)
+	return restoreBackup(engine, req.DbDir, req.walDir(), req)
+}
+
+func restoreBackup(engine *C.rocksdb_backup_engine_t, dbDir, walDir string, req RestoreRequest) error {
+	restoreOpts := C.rocksdb_restore_options_create()
+	defer C.rocksdb_restore_options_destroy(restoreOpts)
+	if req.KeepLogFiles {
+		//void rocksdb_restore_options_set_keep_log_files(rocksdb_restore_options_t* opt, int v);
+		C.rocksdb_restore_options_set_keep_log_files(restoreOpts, 1)
+	}
+	cDb := C.CString(dbDir)
+	defer C.free(unsafe.Pointer(cDb))
+	cWal := C.CString(walDir)
+	defer C.free(unsafe.Pointer(cWal))
+	var err *C.char
+	if req.Latest {
+		//void rocksdb_backup_engine_restore_db_from_latest_backup(rocksdb_backup_engine_t* be, const char* db_dir, const char* wal_dir,
+		//    const rocksdb_restore_options_t* restore_options, char** errptr);
+		C.rocksdb_backup_engine_restore_db_from_latest_backup(engine, cDb, cWal, restoreOpts, &err)
+	} else {
+		//void rocksdb_backup_engine_restore_db_from_backup(
+		//    rocksdb_backup_engine_t* be, const char* db_dir, const char* wal_dir,
+		//    const rocksdb_restore_options_t* restore_options, const uint32_t backup_id,
+		//    char** errptr);
+		C.rocksdb_backup_engine_restore_db_from_backup(engine, cDb, cWal, restoreOpts, C.uint32_t(req.BackupID), &err)
+	}
+	if err != nil {
+		return charErr(err)
+	}
+	return nil
+}
+
+// restoreViaTempDir 恢复到同级的临时目录，保证 rename 不跨文件系统，成功后替换目标目录
+func restoreViaTempDir(engine *C.rocksdb_backup_engine_t, req RestoreRequest) error {
+	dbDir := cleanDbPath(req.DbDir)
+	walDir := cleanDbPath(req.walDir())
+	//上一次替换中断时先恢复原来的目录，否则这次替换会把它当作不存在
+	if _, e := RecoverRestore(dbDir); e != nil {
+		return e
+	}
+	tmpDb, e := makeSiblingTempDir(dbDir)
+	if e != nil {
+		return e
+	}
+	defer os.RemoveAll(tmpDb)
+
+	//WalDir 在 DbDir 中时，随 DbDir 一起替换
+	tmpWal, separateWal := tmpDb, false
+	if rel, e := filepath.Rel(dbDir, walDir); e == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		tmpWal = filepath.Join(tmpDb, rel)
+	} else {
+		if tmpWal, e = makeSiblingTempDir(walDir); e != nil {
+			return e
+		}
+		defer os.RemoveAll(tmpWal)
+		separateWal = true
+	}
+
+	if e = restoreBackup(engine, tmpDb, tmpWal, req); e != nil {
+		return e
+	}
+	//单独的 WalDir 和 DbDir 一起替换，WalDir 替换失败时 DbDir 也恢复原状，不会让新的数据库配上旧的 WAL
+	if separateWal {
+		return swapDirs([2]string{tmpDb, dbDir}, [2]string{tmpWal, walDir})
+	}
+	return swapDirs([2]string{tmpDb, dbDir})
+}
+
+func makeSiblingTempDir(dir string) (string, error) {
+	parent := filepath.Dir(dir)
+	if e := os.MkdirAll(parent, 0755); e != nil {
+		return "", e
+	}
+	return os.MkdirTemp(parent, filepath.Base(dir)+".restore-*")
+}
+
+// swapJournalSuffix 替换目录前在第一个目标目录旁边写入的日志文件，日志存在表示替换还没有完成
+const swapJournalSuffix = ".rocksdb-swap-journal"
+
+// dirSwap 替换中的一个目录：Dst 原来的内容先改名为 Old，然后 Src 改名为 Dst
+type dirSwap struct {
+	Src    string `json:"src"`
+	Dst    string `json:"dst"`
+	Old    string `json:"old"`
+	HadOld bool   `json:"had_old"`
+}
+
+// swapDirs 用每一对中的 src 替换 dst，要么全部替换，要么全部恢复原状。
+// 开始前把全部路径写入第一个 dst 旁边的日志文件，全部改名成功后删除日志，删除日志之后才删除旧目录。
+// 进程在删除日志之前退出时，RecoverRestore 按日志恢复原来的目录，只移动和删除日志中记录的路径
+func swapDirs(pairs ...[2]string) error {
+	journal := pairs[0][1] + swapJournalSuffix
+	stamp := time.Now().UnixNano()
+	swaps := make([]dirSwap, len(pairs))
+	for i, pair := range pairs {
+		swaps[i] = dirSwap{Src: pair[0], Dst: pair[1], Old: fmt.Sprintf("%s.rocksdb-swap-old-%019d", pair[1], stamp)}
+		if _, e := os.Lstat(pair[1]); e == nil {
+			swaps[i].HadOld = true
+		} else if !os.IsNotExist(e) {
+			return e
+		}
+	}
+	if e := writeSwapJournal(journal, swaps); e != nil {
+		return e
+	}
+	for i := range swaps {
+		if e := swaps[i].apply(); e != nil {
+			//第 i 个可能只完成了一半，和前面的一起撤销
+			errs := []error{e}
+			for k := i; k >= 0; k-- {
+				if re := swaps[k].rollback(); re != nil {
+					errs = append(errs, re)
+				}
+			}
+			if len(errs) == 1 {
+				_ = os.Remove(journal)
+			}
+			return errors.Join(errs...)
+		}
+	}
+	//删除日志后替换就完成了，之后删除旧目录失败只会留下 *.rocksdb-swap-old-* 目录，可以手动删除
+	if e := os.Remove(journal); e != nil {
+		return e
+	}
+	var errs []error
+	for _, swap := range swaps {
+		if swap.HadOld {
+			errs = append(errs, os.RemoveAll(swap.Old))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (swap *dirSwap) apply() error {
+	if swap.HadOld {
+		if e := os.Rename(swap.Dst, swap.Old); e != nil {
+			return e
+		}
+	}
+	return os.Rename(swap.Src, swap.Dst)
+}
+
+// rollback 撤销 apply：Src 已经不存在时 Dst 是改名过来的新目录，把它改回 Src，然后把 Old 改回 Dst
+func (swap *dirSwap) rollback() error {
+	if !pathExists(swap.Src) && pathExists(swap.Dst) {
+		if e := os.Rename(swap.Dst, swap.Src); e != nil {
+			return e
+		}
+	}
+	if swap.HadOld && pathExists(swap.Old) {
+		return os.Rename(swap.Old, swap.Dst)
+	}
+	return nil
+}
+
+func pathExists(path string) bool {
+	_, e := os.Lstat(path)
+	return e == nil
+}
+
+// writeSwapJournal 先写临时文件再改名，日志要么完整要么不存在
+func writeSwapJournal(journal string, swaps []dirSwap) error {
+	data, e := json.Marshal(swaps)
+	if e != nil {
+		return e
+	}
+	tmp := journal + ".tmp"
+	f, e := os.Create(tmp)
+	if e != nil {
+		return e
+	}
+	_, e = f.Write(data)
+	if e == nil {
+		e = f.Sync()
+	}
+	if ce := f.Close(); e == nil {
+		e = ce
+	}
+	if e != nil {
+		_ = os.Remove(tmp)
+		return e
+	}
+	return os.Rename(tmp, journal)
+}
+
+// RecoverRestore 撤销被中断的目录替换，应该在打开 dir 中的数据库之前调用。
+// 使用 ViaTempDir 恢复备份和 RestoreFrom 替换目录时，先在 dir 旁边写入 dir.rocksdb-swap-journal，
+// 记录要改名的全部目录（包括单独的 WalDir），替换完成后删除。进程在这之前退出时，按日志把改名过来的新目录
+// 改回临时目录并删除，把旧目录改回原来的名称，得到替换前的数据库，需要的话重新恢复。
+// 只处理日志中记录的路径，没有日志时什么都不做。返回是否撤销了一次中断的替换
+func RecoverRestore(dir string) (bool, error) {
+	dir = cleanDbPath(dir)
+	if isPathOpened(dir) {
+		return false, errDbIsOpened
+	}
+	journal := dir + swapJournalSuffix
+	data, e := os.ReadFile(journal)
+	if os.IsNotExist(e) {
+		return false, nil
+	}
+	if e != nil {
+		return false, e
+	}
+	var swaps []dirSwap
+	if e = json.Unmarshal(data, &swaps); e != nil {
+		return false, fmt.Errorf("invalid swap journal %s: %w", journal, e)
+	}
+	for i := len(swaps) - 1; i >= 0; i-- {
+		if e = swaps[i].rollback(); e != nil {
+			return false, e
+		}
+		//Src 是替换创建的临时目录
+		if e = os.RemoveAll(swaps[i].Src); e != nil {
+			return false, e
+		}
+	}
+	return true, os.Remove(journal)
+}
@@ -0,0 +1,114 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnflushedData 数据库使用 DisableWAL 打开，MemTable 中还有没有刷写的数据，这时关闭会丢失这些数据
+var ErrUnflushedData = errors.New("rocksdb: memtables hold data written without WAL, flush before closing")
+
+// ShutdownOptions Shutdown 关闭数据库前执行的操作
+type ShutdownOptions struct {
+	// FlushMemtables 关闭前把全部 column family 的 MemTable 刷写到 SST 文件并等待完成
+	FlushMemtables bool
+	// WaitForCompactions 关闭前等待正在进行和已经排队的压缩完成
+	WaitForCompactions bool
+	// CancelBackgroundWork 关闭前取消后台的压缩任务并等待它们停止，和 WaitForCompactions 同时设置时先等待压缩，
+	// ctx 结束后再取消
+	CancelBackgroundWork bool
+}
+
+// Shutdown 按 opts 刷写数据、处理后台任务，然后关闭数据库。
+// 数据库使用 DisableWAL 打开并且没有设置 FlushMemtables 时，如果 MemTable 中还有数据，返回 ErrUnflushedData，
+// 数据库保持打开，调用者可以刷写后再关闭，或者确认放弃这些数据后调用 Close。
+// 刷写不能中断，ctx 只限制等待压缩的时间：ctx 结束时取消后台任务并关闭数据库，返回 ctx.Err()，
+// 等待压缩出错时同样会关闭数据库并返回错误。OpenShared 打开的数据库需要使用 SharedDb.Shutdown
+func (rdb *Db) Shutdown(ctx context.Context, opts ShutdownOptions) error {
+	if rdb.sharedKey != "" {
+		return errSharedDb
+	}
+	_, e := rdb.shutdown(ctx, opts)
+	return e
+}
+
+// shutdown 实现 Shutdown，closed 表示数据库是否已经关闭，返回错误时数据库也可能已经关闭
+func (rdb *Db) shutdown(ctx context.Context, opts ShutdownOptions) (closed bool, err error) {
+	if e := ctx.Err(); e != nil {
+		return false, e
+	}
+	def, e := rdb.useDefault()
+	if e != nil {
+		return false, e
+	}
+	db := def.rocks.db
+	if rdb.disableWAL && !opts.FlushMemtables {
+		if entries := rdb.unflushedEntries(); entries > 0 {
+			def.done()
+			return false, fmt.Errorf("%w: %d entries", ErrUnflushedData, entries)
+		}
+	}
+	//Flush 和 WaitForCompact 自己会获取引用，这里的引用只保证 db 在 cancel 之前有效
+	var waitErr error
+	if opts.FlushMemtables {
+		if e = rdb.Flush(nil, true); e != nil {
+			def.done()
+			return false, e
+		}
+	}
+	if opts.WaitForCompactions {
+		waitErr = rdb.waitForCompactCtx(ctx, db)
+	}
+	if opts.CancelBackgroundWork || waitErr != nil {
+		//void rocksdb_cancel_all_background_work(rocksdb_t* db, unsigned char wait);
+		C.rocksdb_cancel_all_background_work(db, 1)
+	}
+	def.done()
+	rdb.close()
+	return true, waitErr
+}
+
+// waitForCompactCtx 等待压缩完成，ctx 结束时取消后台任务让等待提前返回
+func (rdb *Db) waitForCompactCtx(ctx context.Context, db *C.rocksdb_t) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- rdb.WaitForCompact(WaitForCompactOptions{})
+	}()
+	select {
+	case e := <-done:
+		return e
+	case <-ctx.Done():
+		C.rocksdb_cancel_all_background_work(db, 0)
+		//取消后 WaitForCompact 会很快返回，必须等它结束后才能关闭数据库
+		<-done
+		return ctx.Err()
+	}
+}
+
+// unflushedEntries 返回全部 column family 的 MemTable 中的条目数，包括还没有刷写的只读 MemTable
+func (rdb *Db) unflushedEntries() uint64 {
+	rdb.mut.Lock()
+	cfs := rdb.cfList.Values()
+	rdb.mut.Unlock()
+	var total uint64
+	for _, cf := range cfs {
+		active, ok := cf.IntProperty(PropNumEntriesActiveMemTable)
+		if !ok {
+			continue
+		}
+		imm, _ := cf.IntProperty(PropNumEntriesImmMemTables)
+		total += active + imm
+	}
+	return total
+}
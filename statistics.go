@@ -0,0 +1,208 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+var errStatisticsDisabled = errors.New("statistics is not enabled, call Options.EnableStatistics before Open")
+
+// StatisticsLevel 统计信息的收集级别，级别越高开销越大
+type StatisticsLevel int
+
+const (
+	// StatsDisableAll 不收集任何统计
+	StatsDisableAll StatisticsLevel = C.rocksdb_statistics_level_disable_all
+	// StatsExceptHistogramOrTimers 只收集 tickers
+	StatsExceptHistogramOrTimers StatisticsLevel = C.rocksdb_statistics_level_except_histogram_or_timers
+	// StatsExceptTimers 收集 tickers 和 histogram，但是不计时
+	StatsExceptTimers StatisticsLevel = C.rocksdb_statistics_level_except_timers
+	// StatsExceptDetailedTimers 不收集细粒度的计时，这是 rocksdb 的默认级别
+	StatsExceptDetailedTimers StatisticsLevel = C.rocksdb_statistics_level_except_detailed_timers
+	// StatsExceptTimeForMutex 不统计 mutex 的等待时间
+	StatsExceptTimeForMutex StatisticsLevel = C.rocksdb_statistics_level_except_time_for_mutex
+	// StatsAll 收集全部统计
+	StatsAll StatisticsLevel = C.rocksdb_statistics_level_all
+)
+
+// HistogramData 一个 histogram 的统计数据，时间类的 histogram 单位一般是微秒
+type HistogramData struct {
+	P50   float64
+	P95   float64
+	P99   float64
+	Max   float64
+	Count uint64
+	Sum   uint64
+}
+
+// Statistics 数据库的统计信息快照，键是 rocksdb 内部的统计名称，例如 rocksdb.block.cache.miss
+type Statistics struct {
+	Tickers    map[string]uint64
+	Histograms map[string]HistogramData
+}
+
+// EnableStatistics 开启统计信息收集，必须在 Open 之前调用，打开后通过 Db.Statistics 读取
+func (opt *Options) EnableStatistics(level StatisticsLevel) {
+	C.rocksdb_options_enable_statistics(opt.handle)
+	C.rocksdb_options_set_statistics_level(opt.handle, C.int(level))
+}
+
+// Statistics 返回当前全部 tickers 和 histogram 的快照，打开数据库时没有开启统计会返回错误
+func (rdb *Db) Statistics() (*Statistics, error) {
+	//SetDBOptions 会替换 rdb.opts
+	rdb.mut.Lock()
+	defer rdb.mut.Unlock()
+	if rdb.opts == nil {
+		return nil, ErrClosed
+	}
+	//char* rocksdb_options_statistics_get_string(rocksdb_options_t* opt);
+	cStr := C.rocksdb_options_statistics_get_string(rdb.opts)
+	if cStr == nil {
+		return nil, errStatisticsDisabled
+	}
+	text := C.GoString(cStr)
+	C.rocksdb_free(unsafe.Pointer(cStr))
+	return parseStatistics(text), nil
+}
+
+// TickerCount 按 rocksdb 的 Tickers 枚举值读取单个 ticker，枚举值和 rocksdb 版本相关，参考 rocksdb/statistics.h
+func (rdb *Db) TickerCount(ticker uint32) (uint64, error) {
+	//SetDBOptions 会替换 rdb.opts
+	rdb.mut.Lock()
+	defer rdb.mut.Unlock()
+	if rdb.opts == nil {
+		return 0, ErrClosed
+	}
+	if C.rocksdb_options_get_statistics_level(rdb.opts) == C.rocksdb_statistics_level_disable_all {
+		return 0, errStatisticsDisabled
+	}
+	return uint64(C.rocksdb_options_statistics_get_ticker_count(rdb.opts, C.uint32_t(ticker))), nil
+}
+
+// Histogram 按 rocksdb 的 Histograms 枚举值读取单个 histogram，枚举值和 rocksdb 版本相关，参考 rocksdb/statistics.h
+func (rdb *Db) Histogram(histogram uint32) (HistogramData, error) {
+	//SetDBOptions 会替换 rdb.opts
+	rdb.mut.Lock()
+	defer rdb.mut.Unlock()
+	if rdb.opts == nil {
+		return HistogramData{}, ErrClosed
+	}
+	if C.rocksdb_options_get_statistics_level(rdb.opts) == C.rocksdb_statistics_level_disable_all {
+		return HistogramData{}, errStatisticsDisabled
+	}
+	data := C.rocksdb_statistics_histogram_data_create()
+	defer C.rocksdb_statistics_histogram_data_destroy(data)
+	C.rocksdb_options_statistics_get_histogram_data(rdb.opts, C.uint32_t(histogram), data)
+	return HistogramData{
+		P50:   float64(C.rocksdb_statistics_histogram_data_get_median(data)),
+		P95:   float64(C.rocksdb_statistics_histogram_data_get_p95(data)),
+		P99:   float64(C.rocksdb_statistics_histogram_data_get_p99(data)),
+		Max:   float64(C.rocksdb_statistics_histogram_data_get_max(data)),
+		Count: uint64(C.rocksdb_statistics_histogram_data_get_count(data)),
+		Sum:   uint64(C.rocksdb_statistics_histogram_data_get_sum(data)),
+	}, nil
+}
+
+// parseStatistics 解析 rocksdb 统计字符串，每行是一个 ticker 或者 histogram：
+//
+//	rocksdb.block.cache.miss COUNT : 12
+//	rocksdb.db.get.micros P50 : 1.5 P95 : 3.2 P99 : 8.0 P100 : 21.0 COUNT : 100 SUM : 250
+func parseStatistics(text string) *Statistics {
+	stats := &Statistics{
+		Tickers:    map[string]uint64{},
+		Histograms: map[string]HistogramData{},
+	}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		//至少包含 名称 字段 : 值
+		if len(fields) < 4 {
+			continue
+		}
+		name := fields[0]
+		values := map[string]string{}
+		for i := 1; i+2 < len(fields); i += 3 {
+			if fields[i+1] != ":" {
+				break
+			}
+			values[fields[i]] = fields[i+2]
+		}
+		if _, ok := values["P50"]; ok {
+			var h HistogramData
+			h.P50, _ = strconv.ParseFloat(values["P50"], 64)
+			h.P95, _ = strconv.ParseFloat(values["P95"], 64)
+			h.P99, _ = strconv.ParseFloat(values["P99"], 64)
+			h.Max, _ = strconv.ParseFloat(values["P100"], 64)
+			h.Count, _ = strconv.ParseUint(values["COUNT"], 10, 64)
+			h.Sum, _ = strconv.ParseUint(values["SUM"], 10, 64)
+			stats.Histograms[name] = h
+		} else if count, ok := values["COUNT"]; ok {
+			stats.Tickers[name], _ = strconv.ParseUint(count, 10, 64)
+		}
+	}
+	return stats
+}
+
+// WritePrometheus 按 Prometheus 文本格式输出统计信息，tickers 输出为 counter，histogram 输出为 summary
+func (stats *Statistics) WritePrometheus(w io.Writer) error {
+	tickers := make([]string, 0, len(stats.Tickers))
+	for name := range stats.Tickers {
+		tickers = append(tickers, name)
+	}
+	sort.Strings(tickers)
+	for _, name := range tickers {
+		metric := prometheusName(name) + "_total"
+		_, e := fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", metric, metric, stats.Tickers[name])
+		if e != nil {
+			return e
+		}
+	}
+
+	histograms := make([]string, 0, len(stats.Histograms))
+	for name := range stats.Histograms {
+		histograms = append(histograms, name)
+	}
+	sort.Strings(histograms)
+	for _, name := range histograms {
+		metric := prometheusName(name)
+		h := stats.Histograms[name]
+		_, e := fmt.Fprintf(w, "# TYPE %s summary\n"+
+			"%s{quantile=\"0.5\"} %g\n"+
+			"%s{quantile=\"0.95\"} %g\n"+
+			"%s{quantile=\"0.99\"} %g\n"+
+			"%s{quantile=\"1\"} %g\n"+
+			"%s_sum %d\n"+
+			"%s_count %d\n",
+			metric, metric, h.P50, metric, h.P95, metric, h.P99, metric, h.Max, metric, h.Sum, metric, h.Count)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// prometheusName 把 rocksdb.block.cache.miss 转为 rocksdb_block_cache_miss
+func prometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,286 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "c.h"
+*/
+import "C"
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// ArchiveFormat BackupTo 输出的归档格式
+type ArchiveFormat int
+
+const (
+	// ArchiveTar 不压缩的 tar
+	ArchiveTar ArchiveFormat = iota
+	// ArchiveTarGzip gzip 压缩的 tar
+	ArchiveTarGzip
+	// ArchiveTarZstd zstd 压缩的 tar
+	ArchiveTarZstd
+)
+
+// archiveChecksumFile 归档中最后一个文件，每行是一个文件的 sha256，格式和 sha256sum 的输出相同
+const archiveChecksumFile = "BACKUP-SHA256SUMS"
+
+const zstdDefaultLevel = 3
+
+var errArchiveChecksum = errors.New("archive checksum mismatch")
+
+// BackupTo 把数据库的一个一致的快照写入 w，包含全部 column family 的 SST、MANIFEST、OPTIONS 和 WAL 文件。
+// 快照通过 rocksdb 的 checkpoint 生成在数据库同级的临时目录中，SST 文件使用硬链接，写完后删除。
+// 生成 checkpoint 前会刷写 memtable，所以使用 DisableWAL 写入的数据也在快照中。
+// 归档的最后是 BACKUP-SHA256SUMS 文件，RestoreFrom 用它校验每个文件。
+func (rdb *Db) BackupTo(w io.Writer, format ArchiveFormat) (e error) {
+	dir, e := rdb.createCheckpoint()
+	if e != nil {
+		return e
+	}
+	defer os.RemoveAll(dir)
+
+	var out io.WriteCloser
+	switch format {
+	case ArchiveTar:
+		out = nopWriteCloser{w}
+	case ArchiveTarGzip:
+		out = gzip.NewWriter(w)
+	case ArchiveTarZstd:
+		if out, e = newZstdWriter(w, zstdDefaultLevel); e != nil {
+			return e
+		}
+	default:
+		return fmt.Errorf("unknown archive format %d", format)
+	}
+	defer func() {
+		if ce := out.Close(); e == nil {
+			e = ce
+		}
+	}()
+
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		return e
+	}
+	tw := tar.NewWriter(out)
+	var sums bytes.Buffer
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		sum, e := addTarFile(tw, filepath.Join(dir, entry.Name()), entry.Name())
+		if e != nil {
+			return e
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, entry.Name())
+	}
+	if e = tw.WriteHeader(&tar.Header{Name: archiveChecksumFile, Mode: 0644, Size: int64(sums.Len()), Typeflag: tar.TypeReg}); e != nil {
+		return e
+	}
+	if _, e = tw.Write(sums.Bytes()); e != nil {
+		return e
+	}
+	return tw.Close()
+}
+
+// createCheckpoint 在数据库同级目录生成 checkpoint，checkpoint 的目录不能事先存在
+func (rdb *Db) createCheckpoint() (string, error) {
+	if rdb.path == "" {
+		return "", ErrClosed
+	}
+	tmp, e := os.MkdirTemp(filepath.Dir(rdb.path), filepath.Base(rdb.path)+".checkpoint-*")
+	if e != nil {
+		return "", e
+	}
+	if e = os.Remove(tmp); e != nil {
+		return "", e
+	}
+	def, e := rdb.useDefault()
+	if e != nil {
+		return "", e
+	}
+	defer def.done()
+	var err *C.char
+	//rocksdb_checkpoint_t* rocksdb_checkpoint_object_create(rocksdb_t* db, char** errptr);
+	cp := C.rocksdb_checkpoint_object_create(def.rocks.db, &err)
+	if err != nil {
+		return "", charErr(err)
+	}
+	defer C.rocksdb_checkpoint_object_destroy(cp)
+	cDir := C.CString(tmp)
+	defer C.free(unsafe.Pointer(cDir))
+	//log_size_for_flush 为 0 表示总是先刷写 memtable
+	//void rocksdb_checkpoint_create(rocksdb_checkpoint_t* checkpoint, const char* checkpoint_dir, uint64_t log_size_for_flush, char** errptr);
+	C.rocksdb_checkpoint_create(cp, cDir, 0, &err)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", charErr(err)
+	}
+	return tmp, nil
+}
+
+// addTarFile 把文件写入 tar，返回文件内容的 sha256
+func addTarFile(tw *tar.Writer, path, name string) (string, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return "", e
+	}
+	defer f.Close()
+	stat, e := f.Stat()
+	if e != nil {
+		return "", e
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: stat.Size(), ModTime: stat.ModTime(), Typeflag: tar.TypeReg}
+	if e = tw.WriteHeader(hdr); e != nil {
+		return "", e
+	}
+	h := sha256.New()
+	if _, e = io.Copy(io.MultiWriter(tw, h), f); e != nil {
+		return "", e
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// RestoreFrom 把 BackupTo 生成的归档恢复到 dir，自动识别压缩格式。
+// 先解压到 dir 同级的临时目录并按 BACKUP-SHA256SUMS 校验每个文件，全部通过后再改名为 dir，
+// 所以失败时不会留下不完整的数据库。dir 必须不存在或者是空目录，并且没有被当前进程打开。
+// 改名不是原子操作，中断后由 RecoverRestore 处理，RestoreFrom 开始前也会先调用它。
+func RestoreFrom(r io.Reader, dir string) error {
+	dir = cleanDbPath(dir)
+	if _, e := RecoverRestore(dir); e != nil {
+		return e
+	}
+	if entries, e := os.ReadDir(dir); e == nil && len(entries) > 0 {
+		return fmt.Errorf("restore target %s is not empty", dir)
+	}
+
+	br := bufio.NewReader(r)
+	var in io.Reader = br
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gr, e := gzip.NewReader(br)
+		if e != nil {
+			return e
+		}
+		defer gr.Close()
+		in = gr
+	case bytes.Equal(magic, zstdMagic):
+		zr, e := newZstdReader(br)
+		if e != nil {
+			return e
+		}
+		defer zr.Close()
+		in = zr
+	}
+
+	tmp, e := makeSiblingTempDir(dir)
+	if e != nil {
+		return e
+	}
+	defer os.RemoveAll(tmp)
+	if e = extractArchive(in, tmp); e != nil {
+		return e
+	}
+	return swapDirs([2]string{tmp, dir})
+}
+
+// extractArchive 解压 tar 中的文件到 dir，同时计算 sha256，最后和归档中的校验文件比较
+func extractArchive(in io.Reader, dir string) error {
+	tr := tar.NewReader(in)
+	sums := map[string]string{}
+	var expected []byte
+	for {
+		hdr, e := tr.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return e
+		}
+		if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(hdr.Name) || strings.ContainsAny(hdr.Name, `/\`) {
+			return fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+		if hdr.Name == archiveChecksumFile {
+			if expected, e = io.ReadAll(tr); e != nil {
+				return e
+			}
+			continue
+		}
+		if sums[hdr.Name], e = extractTarFile(tr, filepath.Join(dir, hdr.Name)); e != nil {
+			return e
+		}
+	}
+	if expected == nil {
+		return fmt.Errorf("%w: %s is missing", errArchiveChecksum, archiveChecksumFile)
+	}
+	return checkArchiveSums(expected, sums)
+}
+
+func extractTarFile(tr *tar.Reader, path string) (string, error) {
+	f, e := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if e != nil {
+		return "", e
+	}
+	h := sha256.New()
+	_, e = io.Copy(io.MultiWriter(f, h), tr)
+	if e == nil {
+		e = f.Sync()
+	}
+	if ce := f.Close(); e == nil {
+		e = ce
+	}
+	if e != nil {
+		return "", e
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func checkArchiveSums(expected []byte, sums map[string]string) error {
+	listed := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(expected)), "\n") {
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			return fmt.Errorf("%w: invalid line %q", errArchiveChecksum, line)
+		}
+		listed[name] = true
+		if sums[name] != sum {
+			return fmt.Errorf("%w: %s", errArchiveChecksum, name)
+		}
+	}
+	var extra []string
+	for name := range sums {
+		if !listed[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return fmt.Errorf("%w: files not in %s: %s", errArchiveChecksum, archiveChecksumFile, strings.Join(extra, ", "))
+	}
+	return nil
+}
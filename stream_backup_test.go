@@ -0,0 +1,154 @@
+package rocksdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveTestDb 创建有 default 和 tab1 两个 column family 的数据库，每个写入 100 个键，返回写入的数据
+func archiveTestDb(t *testing.T, dir string) (*Db, map[string]string) {
+	db, err := Open(dir, nil, ColumnFamilyDescriptor{Name: "tab1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{}
+	for _, name := range []string{"default", "tab1"} {
+		cf := db.GetColumnFamily(name)
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("%s-%03d", name, i)
+			want[key] = fmt.Sprintf("v%d", i)
+			if err = cf.Put([]byte(key), []byte(want[key])); err != nil {
+				db.Close()
+				t.Fatal(err)
+			}
+		}
+	}
+	return db, want
+}
+
+// backupArchives 把数据库按每种格式打包
+func backupArchives(t *testing.T) (map[string][]byte, map[string]string) {
+	db, want := archiveTestDb(t, filepath.Join(t.TempDir(), "src"))
+	defer db.Close()
+	formats := map[string]ArchiveFormat{"tar": ArchiveTar, "gzip": ArchiveTarGzip, "zstd": ArchiveTarZstd}
+	archives := map[string][]byte{}
+	for name, format := range formats {
+		var buf bytes.Buffer
+		if err := db.BackupTo(&buf, format); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		archives[name] = buf.Bytes()
+	}
+	return archives, want
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	archives, want := backupArchives(t)
+	for name, data := range archives {
+		t.Run(name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "db")
+			if err := RestoreFrom(bytes.NewReader(data), dir); err != nil {
+				t.Fatal(err)
+			}
+			checkArchiveDb(t, dir, want)
+		})
+	}
+}
+
+// TestArchiveCorrupted 篡改了校验文件或者数据文件的归档会被拒绝，并且不留下恢复的目录
+func TestArchiveCorrupted(t *testing.T) {
+	archives, _ := backupArchives(t)
+	corruptions := map[string]func(name string, data []byte) []byte{
+		//校验文件中的第一个 sha256 改错一位
+		"manifest": func(name string, data []byte) []byte {
+			if name != "BACKUP-SHA256SUMS" {
+				return data
+			}
+			data = bytes.Clone(data)
+			data[0] ^= 1
+			return data
+		},
+		//SST 文件内容被修改
+		"checksum": func(name string, data []byte) []byte {
+			if !strings.HasSuffix(name, ".sst") {
+				return data
+			}
+			data = bytes.Clone(data)
+			data[len(data)/2] ^= 0xff
+			return data
+		},
+	}
+	for name, corrupt := range corruptions {
+		t.Run(name, func(t *testing.T) {
+			data := rewriteTar(t, archives["tar"], corrupt)
+			dir := filepath.Join(t.TempDir(), "db")
+			if err := RestoreFrom(bytes.NewReader(data), dir); err == nil {
+				t.Fatal("corrupted archive was restored")
+			}
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Fatalf("corrupted archive left %s behind", dir)
+			}
+		})
+	}
+}
+
+// checkArchiveDb 打开恢复的数据库，检查 want 中的每个键
+func checkArchiveDb(t *testing.T, dir string, want map[string]string) {
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for key, val := range want {
+		cfName, _, _ := strings.Cut(key, "-")
+		cf := db.GetColumnFamily(cfName)
+		if cf == nil {
+			t.Fatalf("column family %s is missing", cfName)
+		}
+		got, err := cf.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != val {
+			t.Fatalf("%s is %q, expected %q", key, got, val)
+		}
+	}
+}
+
+// rewriteTar 复制不压缩的 tar，用 corrupt 修改每个文件的内容
+func rewriteTar(t *testing.T, data []byte, corrupt func(name string, data []byte) []byte) []byte {
+	tr := tar.NewReader(bytes.NewReader(data))
+	var out bytes.Buffer
+	tw := tar.NewWriter(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = corrupt(hdr.Name, content)
+		hdr.Size = int64(len(content))
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
@@ -24,6 +24,14 @@ func toCBytes(data []byte) (*C.char, C.size_t) {
 	}
 	return gNullPtr, 0
 }
+
+// toCRangeKey 和 toCBytes 类似，但是空键返回 NULL，rocksdb 把 NULL 当作范围的这一端没有限制
+func toCRangeKey(key []byte) (*C.char, C.size_t) {
+	if len(key) > 0 {
+		return (*C.char)(unsafe.Pointer(&key[0])), C.size_t(len(key))
+	}
+	return nil, 0
+}
 func charErr(err *C.char) error {
 	if err == nil {
 		return nil
@@ -66,18 +74,17 @@ func ucharToBool(v C.uchar) bool {
 }
 
 func uniqNames(names []string) []string {
+	//保持原来的顺序，AddColumnFamily 按这个顺序返回
 	nm := map[string]bool{}
+	result := make([]string, 0, len(names))
 	for _, name := range names {
-		if name == "" {
+		if name == "" || nm[name] {
 			continue
 		}
 		nm[name] = true
+		result = append(result, name)
 	}
-	names = make([]string, 0, len(nm))
-	for name := range nm {
-		names = append(names, name)
-	}
-	return names
+	return result
 }
 func getExistCfNames(opts *C.rocksdb_options_t, dbPath *C.char) (map[string]bool, error) {
 	var lencf C.size_t
@@ -95,17 +102,3 @@ func getExistCfNames(opts *C.rocksdb_options_t, dbPath *C.char) (map[string]bool
 	C.rocksdb_list_column_families_destroy(existNamesC, lencf)
 	return existNames, nil
 }
-func tryCreateDb(path string, opts *Options) {
-	if opts == nil {
-		opts = GetDefaultOptions()
-		defer opts.Close()
-	}
-	var err *C.char
-	dbPath := C.CString(path)
-	handle := C.rocksdb_open(opts.handle, dbPath, &err)
-	C.free(unsafe.Pointer(dbPath))
-	if err != nil {
-		return
-	}
-	C.rocksdb_close(handle)
-}
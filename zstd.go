@@ -0,0 +1,224 @@
+package rocksdb
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/deps/include
+#cgo linux,amd64 LDFLAGS: ${SRCDIR}/deps/libs/linux_amd64/librocksdb.a -lm -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lrocksdb -lstdc++ -lz -lbz2 -lsnappy -llz4 -lzstd -lshlwapi -lrpcrt4
+
+#include <stdlib.h>
+#include <string.h>
+#include "zstd.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// zstdMagic zstd 帧开头的 4 个字节
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+var errZstdClosed = errors.New("zstd stream is closed")
+
+// checkZstdVersion deps/include/zstd.h 来自 zstd 1.5.7，和 deps 中的 libzstd 相同。
+// 版本号是 major*10000+minor*100+patch，zstd 只保证同一个 major.minor 之内的接口兼容，
+// 链接的 libzstd 主版本或者次版本不同时返回错误而不是继续使用，只有 patch 可以不同
+func checkZstdVersion() error {
+	//unsigned ZSTD_versionNumber(void);
+	linked := uint(C.ZSTD_versionNumber())
+	if linked/100 != uint(C.ZSTD_VERSION_NUMBER)/100 {
+		return fmt.Errorf("zstd: linked library version %d does not match header version %d", linked, uint(C.ZSTD_VERSION_NUMBER))
+	}
+	return nil
+}
+
+func zstdErr(code C.size_t) error {
+	if C.ZSTD_isError(code) == 0 {
+		return nil
+	}
+	return errors.New("zstd: " + C.GoString(C.ZSTD_getErrorName(code)))
+}
+
+// zstdWriter 把写入的数据用 zstd 压缩后写到 w，必须调用 Close 写出最后的数据并释放 C 资源。
+// 输入和输出缓冲区都分配在 C 内存中，避免把 Go 指针传给 C
+type zstdWriter struct {
+	w      io.Writer
+	stream *C.ZSTD_CStream
+	in     unsafe.Pointer
+	inSize int
+	out    unsafe.Pointer
+	outLen C.size_t
+}
+
+func newZstdWriter(w io.Writer, level int) (*zstdWriter, error) {
+	if e := checkZstdVersion(); e != nil {
+		return nil, e
+	}
+	stream := C.ZSTD_createCStream()
+	if stream == nil {
+		return nil, errors.New("zstd: create stream failed")
+	}
+	if e := zstdErr(C.ZSTD_initCStream(stream, C.int(level))); e != nil {
+		C.ZSTD_freeCStream(stream)
+		return nil, e
+	}
+	zw := &zstdWriter{w: w, stream: stream, inSize: int(C.ZSTD_CStreamInSize()), outLen: C.ZSTD_CStreamOutSize()}
+	zw.in = C.malloc(C.size_t(zw.inSize))
+	zw.out = C.malloc(zw.outLen)
+	return zw, nil
+}
+
+func (zw *zstdWriter) Write(p []byte) (int, error) {
+	if zw.stream == nil {
+		return 0, errZstdClosed
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(unsafe.Slice((*byte)(zw.in), zw.inSize), p)
+		input := C.ZSTD_inBuffer{src: zw.in, size: C.size_t(n)}
+		for input.pos < input.size {
+			output := C.ZSTD_outBuffer{dst: zw.out, size: zw.outLen}
+			if e := zstdErr(C.ZSTD_compressStream(zw.stream, &output, &input)); e != nil {
+				return written, e
+			}
+			if e := zw.flushOut(&output); e != nil {
+				return written, e
+			}
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (zw *zstdWriter) flushOut(output *C.ZSTD_outBuffer) error {
+	if output.pos == 0 {
+		return nil
+	}
+	_, e := zw.w.Write(C.GoBytes(zw.out, C.int(output.pos)))
+	return e
+}
+
+// Close 结束 zstd 帧并释放 C 资源，不会关闭底层的 w
+func (zw *zstdWriter) Close() error {
+	if zw.stream == nil {
+		return nil
+	}
+	defer zw.free()
+	for {
+		output := C.ZSTD_outBuffer{dst: zw.out, size: zw.outLen}
+		remaining := C.ZSTD_endStream(zw.stream, &output)
+		if e := zstdErr(remaining); e != nil {
+			return e
+		}
+		if e := zw.flushOut(&output); e != nil {
+			return e
+		}
+		if remaining == 0 {
+			return nil
+		}
+	}
+}
+
+func (zw *zstdWriter) free() {
+	C.ZSTD_freeCStream(zw.stream)
+	C.free(zw.in)
+	C.free(zw.out)
+	zw.stream = nil
+}
+
+// zstdReader 解压 r 中的 zstd 数据，用完需要调用 Close 释放 C 资源
+type zstdReader struct {
+	r      io.Reader
+	stream *C.ZSTD_DStream
+	in     unsafe.Pointer
+	inSize int
+	input  C.ZSTD_inBuffer
+	out    unsafe.Pointer
+	outLen C.size_t
+	buf    []byte
+	//frameDone 最后一次解压时当前帧已经结束，这时遇到 EOF 是正常结束
+	frameDone bool
+	//outFull 最后一次解压填满了输出缓冲区，解压器中可能还有数据，需要在读取新的输入前继续解压
+	outFull bool
+	srcEOF  bool
+}
+
+func newZstdReader(r io.Reader) (*zstdReader, error) {
+	if e := checkZstdVersion(); e != nil {
+		return nil, e
+	}
+	stream := C.ZSTD_createDStream()
+	if stream == nil {
+		return nil, errors.New("zstd: create stream failed")
+	}
+	if e := zstdErr(C.ZSTD_initDStream(stream)); e != nil {
+		C.ZSTD_freeDStream(stream)
+		return nil, e
+	}
+	zr := &zstdReader{r: r, stream: stream, inSize: int(C.ZSTD_DStreamInSize()), outLen: C.ZSTD_DStreamOutSize(), frameDone: true}
+	zr.in = C.malloc(C.size_t(zr.inSize))
+	zr.out = C.malloc(zr.outLen)
+	zr.input.src = zr.in
+	return zr, nil
+}
+
+func (zr *zstdReader) Read(p []byte) (int, error) {
+	if zr.stream == nil {
+		return 0, errZstdClosed
+	}
+	for len(zr.buf) == 0 {
+		if zr.input.pos == zr.input.size && !zr.outFull {
+			if zr.srcEOF {
+				if zr.frameDone {
+					return 0, io.EOF
+				}
+				return 0, io.ErrUnexpectedEOF
+			}
+			if e := zr.fill(); e != nil {
+				return 0, e
+			}
+			continue
+		}
+		output := C.ZSTD_outBuffer{dst: zr.out, size: zr.outLen}
+		ret := C.ZSTD_decompressStream(zr.stream, &output, &zr.input)
+		if e := zstdErr(ret); e != nil {
+			return 0, e
+		}
+		zr.frameDone = ret == 0
+		zr.outFull = output.pos == output.size
+		if output.pos > 0 {
+			zr.buf = C.GoBytes(zr.out, C.int(output.pos))
+		}
+	}
+	n := copy(p, zr.buf)
+	zr.buf = zr.buf[n:]
+	return n, nil
+}
+
+// fill 从 r 读取下一段压缩数据到 C 缓冲区
+func (zr *zstdReader) fill() error {
+	chunk := make([]byte, zr.inSize)
+	n, e := zr.r.Read(chunk)
+	if e == io.EOF {
+		zr.srcEOF = true
+	} else if e != nil {
+		return e
+	}
+	copy(unsafe.Slice((*byte)(zr.in), zr.inSize), chunk[:n])
+	zr.input.size = C.size_t(n)
+	zr.input.pos = 0
+	return nil
+}
+
+func (zr *zstdReader) Close() error {
+	if zr.stream != nil {
+		C.ZSTD_freeDStream(zr.stream)
+		C.free(zr.in)
+		C.free(zr.out)
+		zr.stream = nil
+	}
+	return nil
+}